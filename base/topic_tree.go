@@ -0,0 +1,188 @@
+package base
+
+import (
+	"strings"
+	"sync"
+)
+
+const (
+	topicSeparator      = "/"
+	singleLevelWildcard = "+"
+	multiLevelWildcard  = "#"
+	systemTopicPrefix   = "$"
+)
+
+// NewTopicTree creates a new subscription index.
+func NewTopicTree[K comparable, V any]() *TopicTree[K, V] {
+	return &TopicTree[K, V]{root: newTopicNode[K, V](nil, "")}
+}
+
+// TopicTree is a concurrent subscription index keyed by topic levels.
+// Each topic filter holds one value per subscriber.
+type TopicTree[K comparable, V any] struct {
+	mu   sync.RWMutex
+	root *topicNode[K, V]
+	size int
+}
+
+type topicNode[K comparable, V any] struct {
+	parent   *topicNode[K, V]
+	level    string
+	children map[string]*topicNode[K, V]
+	subs     map[K]V
+}
+
+func newTopicNode[K comparable, V any](parent *topicNode[K, V], level string) *topicNode[K, V] {
+	return &topicNode[K, V]{parent: parent, level: level, children: map[string]*topicNode[K, V]{}}
+}
+
+// Insert stores the value of a subscriber for a topic filter. It returns whether the subscriber already subscribed to it.
+func (t *TopicTree[K, V]) Insert(filter string, subscriber K, value V) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := t.root
+	for _, level := range strings.Split(filter, topicSeparator) {
+		child, ok := n.children[level]
+		if !ok {
+			child = newTopicNode(n, level)
+			n.children[level] = child
+		}
+		n = child
+	}
+	if n.subs == nil {
+		n.subs = map[K]V{}
+	}
+	_, existed := n.subs[subscriber]
+	n.subs[subscriber] = value
+	if !existed {
+		t.size++
+	}
+	return existed
+}
+
+// Remove deletes the subscription of a subscriber to a topic filter. It returns whether the subscription existed.
+func (t *TopicTree[K, V]) Remove(filter string, subscriber K) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := t.find(filter)
+	if n == nil {
+		return false
+	}
+	if _, ok := n.subs[subscriber]; !ok {
+		return false
+	}
+	delete(n.subs, subscriber)
+	t.size--
+	// prune the branch that no longer holds subscriptions
+	for n.parent != nil && len(n.subs) == 0 && len(n.children) == 0 {
+		delete(n.parent.children, n.level)
+		n = n.parent
+	}
+	return true
+}
+
+// Get loads the value of a subscriber for a topic filter.
+func (t *TopicTree[K, V]) Get(filter string, subscriber K) (value V, exist bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	n := t.find(filter)
+	if n == nil {
+		return
+	}
+	value, exist = n.subs[subscriber]
+	return
+}
+
+// Range calls fn for every subscription to exactly a topic filter, until fn returns false.
+// fn must not modify the tree.
+func (t *TopicTree[K, V]) Range(filter string, fn func(subscriber K, value V) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	n := t.find(filter)
+	if n == nil {
+		return
+	}
+	for subscriber, value := range n.subs {
+		if !fn(subscriber, value) {
+			return
+		}
+	}
+}
+
+// find returns the node of a topic filter, or nil if there is none. The caller holds the lock.
+func (t *TopicTree[K, V]) find(filter string) *topicNode[K, V] {
+	n := t.root
+	for _, level := range strings.Split(filter, topicSeparator) {
+		n = n.children[level]
+		if n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// Match calls fn for every subscription whose topic filter matches a topic name, until fn returns false.
+// Topic names starting with '$' are not matched by filters starting with a wildcard. [MQTT-4.7.2-1]
+// fn must not modify the tree.
+func (t *TopicTree[K, V]) Match(topic string, fn func(filter string, subscriber K, value V) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	levels := strings.Split(topic, topicSeparator)
+	t.root.match(levels, 0, strings.HasPrefix(topic, systemTopicPrefix), fn)
+}
+
+func (n *topicNode[K, V]) match(levels []string, depth int, system bool, fn func(string, K, V) bool) bool {
+	wildcard := !(system && depth == 0)
+	// '#' also matches the parent level, so "a/#" matches "a" [MQTT-4.7.1-2]
+	if child, ok := n.children[multiLevelWildcard]; ok && wildcard {
+		if !child.visit(fn) {
+			return false
+		}
+	}
+	if depth == len(levels) {
+		return n.visit(fn)
+	}
+	if child, ok := n.children[levels[depth]]; ok {
+		if !child.match(levels, depth+1, system, fn) {
+			return false
+		}
+	}
+	if child, ok := n.children[singleLevelWildcard]; ok && wildcard {
+		if !child.match(levels, depth+1, system, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *topicNode[K, V]) visit(fn func(string, K, V) bool) bool {
+	if len(n.subs) == 0 {
+		return true
+	}
+	filter := n.filter()
+	for subscriber, value := range n.subs {
+		if !fn(filter, subscriber, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// filter rebuilds the topic filter of a node from its levels.
+func (n *topicNode[K, V]) filter() string {
+	var levels []string
+	for ; n.parent != nil; n = n.parent {
+		levels = append(levels, n.level)
+	}
+	for i, j := 0, len(levels)-1; i < j; i, j = i+1, j-1 {
+		levels[i], levels[j] = levels[j], levels[i]
+	}
+	return strings.Join(levels, topicSeparator)
+}
+
+// Len returns the number of subscriptions.
+func (t *TopicTree[K, V]) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.size
+}
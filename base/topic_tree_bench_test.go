@@ -0,0 +1,64 @@
+package base
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+const tsize = 10000
+
+func newBenchTopicTree() (*TopicTree[int, int], []string) {
+	tree := NewTopicTree[int, int]()
+	check := []string{}
+	for i := 0; i < tsize; i++ {
+		topic := fmt.Sprintf("device/%d/sensor/%d", rand.Intn(tsize), i%16)
+		switch i % 10 {
+		case 0:
+			tree.Insert(fmt.Sprintf("device/%d/#", rand.Intn(tsize)), i, i)
+		case 1:
+			tree.Insert(fmt.Sprintf("device/+/sensor/%d", i%16), i, i)
+		default:
+			tree.Insert(topic, i, i)
+		}
+		if i%10 == 0 {
+			check = append(check, topic)
+		}
+	}
+	return tree, check
+}
+
+func BenchmarkTopicTreeMatch(b *testing.B) {
+	b.StopTimer()
+	tree, check := newBenchTopicTree()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		for _, c := range check {
+			tree.Match(c, func(string, int, int) bool { return true })
+		}
+	}
+}
+
+func BenchmarkTopicTreeInsertRemove(b *testing.B) {
+	b.StopTimer()
+	tree, _ := newBenchTopicTree()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		filter := fmt.Sprintf("device/%d/+", i%tsize)
+		tree.Insert(filter, i, i)
+		tree.Remove(filter, i)
+	}
+}
+
+func BenchmarkTopicTreeParallelMatch(b *testing.B) {
+	b.StopTimer()
+	tree, check := newBenchTopicTree()
+	b.StartTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			tree.Match(check[i%len(check)], func(string, int, int) bool { return true })
+			i++
+		}
+	})
+}
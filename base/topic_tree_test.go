@@ -0,0 +1,149 @@
+package base
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func matchFilters(tree *TopicTree[string, int], topic string) string {
+	var matched []string
+	tree.Match(topic, func(filter string, subscriber string, value int) bool {
+		matched = append(matched, filter+"@"+subscriber)
+		return true
+	})
+	sort.Strings(matched)
+	return strings.Join(matched, ",")
+}
+
+// TestTopicTreeMatch tests wildcard matching of the topic tree.
+func TestTopicTreeMatch(t *testing.T) {
+	tree := NewTopicTree[string, int]()
+	for _, filter := range []string{
+		"sport/tennis/player1",
+		"sport/tennis/player1/#",
+		"sport/#",
+		"sport/+",
+		"sport/+/player1",
+		"+/+",
+		"+",
+		"#",
+		"/finance",
+		"+/finance",
+		"$SYS/#",
+		"$SYS/monitor/+",
+	} {
+		tree.Insert(filter, "c1", 1)
+	}
+
+	caseList := []struct {
+		topic  string
+		expect string
+	}{
+		{
+			topic:  "sport/tennis/player1",
+			expect: "#@c1,sport/#@c1,sport/+/player1@c1,sport/tennis/player1/#@c1,sport/tennis/player1@c1",
+		},
+		{
+			topic:  "sport/tennis/player1/ranking",
+			expect: "#@c1,sport/#@c1,sport/tennis/player1/#@c1",
+		},
+		{
+			topic:  "sport",
+			expect: "#@c1,+@c1,sport/#@c1",
+		},
+		{
+			topic:  "sport/",
+			expect: "#@c1,+/+@c1,sport/#@c1,sport/+@c1",
+		},
+		{
+			topic:  "/finance",
+			expect: "#@c1,+/+@c1,+/finance@c1,/finance@c1",
+		},
+		{
+			topic:  "$SYS/monitor/Clients",
+			expect: "$SYS/#@c1,$SYS/monitor/+@c1",
+		},
+		{
+			topic:  "$SYS",
+			expect: "$SYS/#@c1",
+		},
+	}
+	for _, c := range caseList {
+		t.Run(c.topic, func(t *testing.T) {
+			if got := matchFilters(tree, c.topic); got != c.expect {
+				t.Errorf("expected \n%s\nbut got \n%s", c.expect, got)
+			}
+		})
+	}
+}
+
+// TestTopicTreeOperations tests insert, get and remove of subscriptions.
+func TestTopicTreeOperations(t *testing.T) {
+	tree := NewTopicTree[string, int]()
+	if existed := tree.Insert("a/+", "c1", 1); existed {
+		t.Errorf("expected a new subscription")
+	}
+	if existed := tree.Insert("a/+", "c1", 2); !existed {
+		t.Errorf("expected an existing subscription")
+	}
+	tree.Insert("a/+", "c2", 3)
+	tree.Insert("a/b/#", "c2", 4)
+	if tree.Len() != 3 {
+		t.Errorf("expected 3 subscriptions but got %d", tree.Len())
+	}
+	if v, ok := tree.Get("a/+", "c1"); !ok || v != 2 {
+		t.Errorf("expected (2, true) but got (%d, %v)", v, ok)
+	}
+	if got := matchFilters(tree, "a/b"); got != "a/+@c1,a/+@c2,a/b/#@c2" {
+		t.Errorf("unexpected match %s", got)
+	}
+	ranged := 0
+	tree.Range("a/+", func(subscriber string, value int) bool {
+		ranged++
+		return true
+	})
+	if ranged != 2 {
+		t.Errorf("expected 2 subscriptions to a/+ but got %d", ranged)
+	}
+
+	if removed := tree.Remove("a/+", "c3"); removed {
+		t.Errorf("expected no subscription to remove")
+	}
+	if removed := tree.Remove("a/b/#", "c2"); !removed {
+		t.Errorf("expected the subscription to be removed")
+	}
+	if _, ok := tree.root.children["a"].children["b"]; ok {
+		t.Errorf("expected the empty branch to be pruned")
+	}
+	tree.Remove("a/+", "c1")
+	tree.Remove("a/+", "c2")
+	if tree.Len() != 0 || len(tree.root.children) != 0 {
+		t.Errorf("expected an empty tree")
+	}
+	if _, ok := tree.Get("a/+", "c1"); ok {
+		t.Errorf("expected no subscription")
+	}
+}
+
+// TestTopicTreeConcurrent tests concurrent use of the topic tree.
+func TestTopicTreeConcurrent(t *testing.T) {
+	tree := NewTopicTree[int, int]()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				tree.Insert("a/+/c", id, j)
+				tree.Match("a/b/c", func(string, int, int) bool { return true })
+				tree.Remove("a/+/c", id)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if tree.Len() != 0 {
+		t.Errorf("expected an empty tree but got %d subscriptions", tree.Len())
+	}
+}
@@ -0,0 +1,221 @@
+package packet
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// AUTH – Authentication exchange
+type Authentication struct {
+	ReasonCode RCode                     `json:"reason_code"`
+	Properties *AuthenticationProperties `json:"properties,omitempty"`
+}
+
+type AuthenticationProperties struct {
+	AuthenticationMethod string          `json:"authentication_method,omitempty"`
+	AuthenticationData   []byte          `json:"authentication_data,omitempty"`
+	ReasonString         string          `json:"reason_string,omitempty"`
+	UserProperty         []*UserProperty `json:"user_property,omitempty"`
+}
+
+// authReasonCodes holds the reason codes an AUTH may carry and the directions they are legal in.
+var authReasonCodes = map[RCode]Direction{
+	RCSuccess:                ClientToServer | ServerToClient,
+	RCContinueAuthentication: ClientToServer | ServerToClient,
+	RCReAuthenticate:         ClientToServer,
+}
+
+func (ap *AuthenticationProperties) Encode(buf *bytes.Buffer) error {
+	var err error
+	tmpBuf := bytes.NewBuffer(nil)
+
+	if ap.AuthenticationMethod != "" {
+		err = tmpBuf.WriteByte(byte(IDAuthenticationMethod))
+		if err != nil {
+			return err
+		}
+		_, err = tmpBuf.Write(encodeString(ap.AuthenticationMethod))
+		if err != nil {
+			return err
+		}
+	}
+	if ap.AuthenticationData != nil {
+		err = tmpBuf.WriteByte(byte(IDAuthenticationData))
+		if err != nil {
+			return err
+		}
+		_, err = tmpBuf.Write(encodeBytes(ap.AuthenticationData))
+		if err != nil {
+			return err
+		}
+	}
+	if ap.ReasonString != "" {
+		err = tmpBuf.WriteByte(byte(IDReasonString))
+		if err != nil {
+			return err
+		}
+		_, err = tmpBuf.Write(encodeString(ap.ReasonString))
+		if err != nil {
+			return err
+		}
+	}
+	for _, prop := range ap.UserProperty {
+		err = tmpBuf.WriteByte(byte(IDUserProperty))
+		if err != nil {
+			return err
+		}
+		_, err = tmpBuf.Write(encodeString(prop.Key))
+		if err != nil {
+			return err
+		}
+		_, err = tmpBuf.Write(encodeString(prop.Val))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = buf.Write(encodeLength(uint32(tmpBuf.Len())))
+	if err != nil {
+		return err
+	}
+	if tmpBuf.Len() > 0 {
+		_, err = buf.Write(tmpBuf.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ap *AuthenticationProperties) Decode(buf []byte) ([]byte, error) {
+	var length uint32
+	var err error
+	length, buf, err = decodeLength(buf)
+	if err != nil {
+		return buf, err
+	}
+	if length == 0 {
+		return buf, nil
+	}
+	shouldRemain := len(buf) - int(length)
+	for len(buf) > shouldRemain {
+		var id Identifier
+		id, buf, err = decodeIdentifier(buf)
+		if err != nil {
+			return buf, err
+		}
+		switch id {
+		case IDAuthenticationMethod:
+			ap.AuthenticationMethod, buf, err = decodeString(buf)
+		case IDAuthenticationData:
+			ap.AuthenticationData, buf, err = decodeBytes(buf)
+		case IDReasonString:
+			ap.ReasonString, buf, err = decodeString(buf)
+		case IDUserProperty:
+			var key, value string
+			key, value, buf, err = decodeStringPair(buf)
+			if err == nil {
+				ap.UserProperty = append(ap.UserProperty, &UserProperty{Key: key, Val: value})
+			}
+		default:
+			err = fmt.Errorf("unknown property id: %d", id)
+		}
+		if err != nil {
+			return buf, err
+		}
+	}
+	return buf, nil
+}
+
+// Decode decodes the variable header. An empty packet is a successful authentication. [MQTT-3.15.2.1]
+// The packet type is reserved before v5, so the packet is malformed.
+func (a *Authentication) Decode(ver ProtocolVersion, buf []byte) error {
+	if ver != ProtoVer5 {
+		return RCMalformedPacket
+	}
+	var err error
+	if len(buf) == 0 {
+		a.ReasonCode = RCSuccess
+		return nil
+	}
+	a.ReasonCode, buf, err = decodeRCode(buf)
+	if err != nil {
+		return err
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	a.Properties = &AuthenticationProperties{}
+	buf, err = a.Properties.Decode(buf)
+	if err != nil {
+		return err
+	}
+	if len(buf) > 0 {
+		return RCMalformedPacket
+	}
+	return nil
+}
+
+func (a *Authentication) Encode(ver ProtocolVersion, buf *bytes.Buffer) error {
+	if ver != ProtoVer5 { // [MQTT-4.12]
+		return RCProtocolError
+	}
+	if a.ReasonCode == RCSuccess && a.Properties == nil {
+		return nil
+	}
+	err := buf.WriteByte(byte(a.ReasonCode))
+	if err != nil {
+		return err
+	}
+	if a.Properties != nil {
+		err = a.Properties.Encode(buf)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Authentication) Type() CPType {
+	return AUTH
+}
+
+// Method returns the authentication method, or an empty string if there is none.
+func (a *Authentication) Method() string {
+	if a.Properties == nil {
+		return ""
+	}
+	return a.Properties.AuthenticationMethod
+}
+
+// Data returns the authentication data, or nil if there is none.
+func (a *Authentication) Data() []byte {
+	if a.Properties == nil {
+		return nil
+	}
+	return a.Properties.AuthenticationData
+}
+
+func (a *Authentication) Validate() RCode {
+	if _, ok := authReasonCodes[a.ReasonCode]; !ok {
+		return RCProtocolError
+	}
+	if a.ReasonCode == RCSuccess && a.Properties == nil {
+		return RCSuccess
+	}
+	if a.Method() == "" { // [MQTT-3.15.2.2.2]
+		return RCProtocolError
+	}
+	return RCSuccess
+}
+
+// ValidateDirection checks that the reason code may be sent in the given direction.
+func (a *Authentication) ValidateDirection(dir Direction) RCode {
+	if rcode := a.Validate(); rcode != RCSuccess {
+		return rcode
+	}
+	if authReasonCodes[a.ReasonCode]&dir == 0 {
+		return RCProtocolError
+	}
+	return RCSuccess
+}
@@ -0,0 +1,155 @@
+package packet
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type AuthCodecTestcase struct {
+	Name      string
+	EncodeVer ProtocolVersion
+
+	// data
+	Request      *Authentication
+	RequestBytes []byte
+}
+
+func TestAuth(t *testing.T) {
+	encodeRunner := func(t *testing.T, tc AuthCodecTestcase) bool {
+		rcode := tc.Request.Validate()
+		if rcode != RCSuccess {
+			t.Errorf("expected \n%v\nbut got \n%v", RCSuccess, rcode)
+			return false
+		}
+		buf := bytes.NewBuffer(nil)
+		err := tc.Request.Encode(tc.EncodeVer, buf)
+		if err != nil {
+			t.Errorf("expected \n%v\nbut got \n%v", tc.RequestBytes, err)
+			return false
+		}
+		if !bytes.Equal(buf.Bytes(), tc.RequestBytes) {
+			t.Errorf("\nexpected \n%v\ngot \n%v", tc.RequestBytes, buf.Bytes())
+			return false
+		}
+		return true
+	}
+
+	decodeRunner := func(t *testing.T, tc AuthCodecTestcase) bool {
+		request := &Authentication{}
+		err := request.Decode(tc.EncodeVer, tc.RequestBytes)
+		if err != nil {
+			t.Errorf("decode expected \n%v\nbut got \n%v", JSON(tc.Request), err)
+			return false
+		}
+		if !reflect.DeepEqual(tc.Request, request) {
+			t.Errorf("decode expected \n%v\nbut got \n%v", JSON(tc.Request), JSON(request))
+			return false
+		}
+		return true
+	}
+
+	for _, tc := range AuthCodecTestcases {
+		t.Run(tc.Name+" decode", func(t *testing.T) {
+			if !decodeRunner(t, tc) {
+				t.FailNow()
+			}
+		})
+		t.Run(tc.Name+" encode", func(t *testing.T) {
+			if !encodeRunner(t, tc) {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+var AuthCodecTestcases = []AuthCodecTestcase{
+	{
+		Name:         "success without properties",
+		EncodeVer:    ProtoVer5,
+		Request:      &Authentication{},
+		RequestBytes: []byte{},
+	},
+	{
+		Name:      "continue authentication",
+		EncodeVer: ProtoVer5,
+		Request: &Authentication{
+			ReasonCode: RCContinueAuthentication,
+			Properties: &AuthenticationProperties{
+				AuthenticationMethod: "SCRAM",
+				AuthenticationData:   []byte("nonce"),
+				ReasonString:         "next",
+				UserProperty: []*UserProperty{
+					{Key: "user1", Val: "value1"},
+				},
+			},
+		},
+		RequestBytes: []byte{
+			byte(RCContinueAuthentication), // Reason Code
+			39,                             // Properties Length
+			byte(IDAuthenticationMethod),   // Authentication Method ID
+			0, 5, 'S', 'C', 'R', 'A', 'M',  // Authentication Method Value
+			byte(IDAuthenticationData),    // Authentication Data ID
+			0, 5, 'n', 'o', 'n', 'c', 'e', // Authentication Data Value
+			byte(IDReasonString),     // Reason String ID
+			0, 4, 'n', 'e', 'x', 't', // Reason String Value
+			byte(IDUserProperty),                                              // User Property ID
+			0, 5, 'u', 's', 'e', 'r', '1', 0, 6, 'v', 'a', 'l', 'u', 'e', '1', // User Property 1
+		},
+	},
+}
+
+func TestAuthDecodeBeforeV5(t *testing.T) {
+	for _, ver := range []ProtocolVersion{ProtoVer31, ProtoVer311} {
+		if err := (&Authentication{}).Decode(ver, []byte{}); err != RCMalformedPacket {
+			t.Errorf("expected %v for version %d but got %v", RCMalformedPacket, ver, err)
+		}
+	}
+}
+
+func TestAuthValidateDirection(t *testing.T) {
+	caseList := []struct {
+		name   string
+		packet *Authentication
+		dir    Direction
+		expect RCode
+	}{
+		{
+			name: "re-authenticate from client",
+			packet: &Authentication{
+				ReasonCode: RCReAuthenticate,
+				Properties: &AuthenticationProperties{AuthenticationMethod: "SCRAM"},
+			},
+			dir:    ClientToServer,
+			expect: RCSuccess,
+		},
+		{
+			name: "re-authenticate from server",
+			packet: &Authentication{
+				ReasonCode: RCReAuthenticate,
+				Properties: &AuthenticationProperties{AuthenticationMethod: "SCRAM"},
+			},
+			dir:    ServerToClient,
+			expect: RCProtocolError,
+		},
+		{
+			name:   "continue without method",
+			packet: &Authentication{ReasonCode: RCContinueAuthentication},
+			dir:    ServerToClient,
+			expect: RCProtocolError,
+		},
+		{
+			name:   "not an auth reason code",
+			packet: &Authentication{ReasonCode: RCNotAuthorized},
+			dir:    ServerToClient,
+			expect: RCProtocolError,
+		},
+	}
+	for _, c := range caseList {
+		t.Run(c.name, func(t *testing.T) {
+			if rcode := c.packet.ValidateDirection(c.dir); rcode != c.expect {
+				t.Errorf("expected %v but got %v", c.expect, rcode)
+			}
+		})
+	}
+}
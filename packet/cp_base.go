@@ -96,3 +96,60 @@ func (pa *BaseProperties) Decode(buf []byte) ([]byte, error) {
 
 	return buf, nil
 }
+
+// decodeAck decodes the variable header of a PUBACK, PUBREC, PUBREL or PUBCOMP. Before v5 it only holds the packet id.
+// A v5 packet may omit the reason code if it is RCSuccess, and the properties if there are none.
+func decodeAck(ver ProtocolVersion, buf []byte, props *BaseProperties) (uint16, RCode, error) {
+	id, buf, err := decodeUint16(buf)
+	if err != nil {
+		return 0, 0, err
+	}
+	if ver != ProtoVer5 {
+		if len(buf) > 0 {
+			return 0, 0, RCMalformedPacket
+		}
+		return id, RCSuccess, nil
+	}
+	if len(buf) == 0 {
+		return id, RCSuccess, nil
+	}
+	var code RCode
+	code, buf, err = decodeRCode(buf)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(buf) == 0 {
+		return id, code, nil
+	}
+	buf, err = props.Decode(buf)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(buf) > 0 {
+		return 0, 0, RCMalformedPacket
+	}
+	return id, code, nil
+}
+
+// encodeAck encodes the variable header of a PUBACK, PUBREC, PUBREL or PUBCOMP, in its shortest form.
+func encodeAck(ver ProtocolVersion, buf *bytes.Buffer, id uint16, code RCode, props *BaseProperties) error {
+	_, err := buf.Write(encodeUint16(id))
+	if err != nil {
+		return err
+	}
+	if ver != ProtoVer5 {
+		return nil
+	}
+	hasProps := props.ReasonString != "" || len(props.UserProperty) > 0
+	if code == RCSuccess && !hasProps {
+		return nil
+	}
+	err = buf.WriteByte(byte(code))
+	if err != nil {
+		return err
+	}
+	if !hasProps {
+		return nil
+	}
+	return props.Encode(buf)
+}
@@ -14,16 +14,16 @@ type ConnectAcknowledgement struct {
 type ConnectAcknowledgementProperties struct {
 	SessionExpiryInterval           uint32          `json:"session_expiry_interval,omitempty"`
 	ReceiveMaximum                  uint16          `json:"receive_maximum,omitempty"`
-	MaximumQoS                      QoS             `json:"maximum_qos,omitempty"`
-	RetainAvailable                 uint8           `json:"retain_available,omitempty"`
+	MaximumQoS                      FlagV[QoS]      `json:"maximum_qos,omitempty"`
+	RetainAvailable                 FlagV[uint8]    `json:"retain_available,omitempty"`
 	MaximumPacketSize               uint32          `json:"maximum_packet_size,omitempty"`
 	AssignedClientIdentifier        string          `json:"assigned_client_identifier,omitempty"`
 	TopicAliasMaximum               uint16          `json:"topic_alias_maximum,omitempty"`
 	ReasonString                    string          `json:"reason_string,omitempty"`
 	UserProperty                    []*UserProperty `json:"user_property,omitempty"`
-	WildcardSubscriptionAvailable   uint8           `json:"wildcard_subscription_available,omitempty"`
-	SubscriptionIdentifierAvailable uint8           `json:"subscription_identifier_available,omitempty"`
-	SharedSubscriptionAvailable     uint8           `json:"shared_subscription_available,omitempty"`
+	WildcardSubscriptionAvailable   FlagV[uint8]    `json:"wildcard_subscription_available,omitempty"`
+	SubscriptionIdentifierAvailable FlagV[uint8]    `json:"subscription_identifier_available,omitempty"`
+	SharedSubscriptionAvailable     FlagV[uint8]    `json:"shared_subscription_available,omitempty"`
 	ServerKeepAlive                 uint16          `json:"server_keep_alive,omitempty"`
 	ResponseInformation             string          `json:"response_information,omitempty"`
 	ServerReference                 string          `json:"server_reference,omitempty"`
@@ -55,22 +55,22 @@ func (cap *ConnectAcknowledgementProperties) Encode(buf *bytes.Buffer) error {
 			return err
 		}
 	}
-	if cap.MaximumQoS != 0 {
+	if cap.MaximumQoS.Flag() { // absent means QoS 2 is supported, so 0 must be sent explicitly
 		err = tmpBuf.WriteByte(byte(IDMaximumQoS))
 		if err != nil {
 			return err
 		}
-		err = tmpBuf.WriteByte(byte(cap.MaximumQoS))
+		err = tmpBuf.WriteByte(byte(cap.MaximumQoS.Value()))
 		if err != nil {
 			return err
 		}
 	}
-	if cap.RetainAvailable != 0 {
+	if cap.RetainAvailable.Flag() { // absent means retain is available, so 0 must be sent explicitly
 		err = tmpBuf.WriteByte(byte(IDRetainAvailable))
 		if err != nil {
 			return err
 		}
-		err = tmpBuf.WriteByte(cap.RetainAvailable)
+		err = tmpBuf.WriteByte(cap.RetainAvailable.Value())
 		if err != nil {
 			return err
 		}
@@ -131,32 +131,32 @@ func (cap *ConnectAcknowledgementProperties) Encode(buf *bytes.Buffer) error {
 			}
 		}
 	}
-	if cap.WildcardSubscriptionAvailable != 0 {
+	if cap.WildcardSubscriptionAvailable.Flag() { // absent means wildcard subscriptions are available
 		err = tmpBuf.WriteByte(byte(IDWildcardSubAvailable))
 		if err != nil {
 			return err
 		}
-		err = tmpBuf.WriteByte(cap.WildcardSubscriptionAvailable)
+		err = tmpBuf.WriteByte(cap.WildcardSubscriptionAvailable.Value())
 		if err != nil {
 			return err
 		}
 	}
-	if cap.SubscriptionIdentifierAvailable != 0 {
+	if cap.SubscriptionIdentifierAvailable.Flag() { // absent means subscription identifiers are available
 		err = tmpBuf.WriteByte(byte(IDSubIDAvailable))
 		if err != nil {
 			return err
 		}
-		err = tmpBuf.WriteByte(cap.SubscriptionIdentifierAvailable)
+		err = tmpBuf.WriteByte(cap.SubscriptionIdentifierAvailable.Value())
 		if err != nil {
 			return err
 		}
 	}
-	if cap.SharedSubscriptionAvailable != 0 {
+	if cap.SharedSubscriptionAvailable.Flag() { // absent means shared subscriptions are available
 		err = tmpBuf.WriteByte(byte(IDSharedSubAvailable))
 		if err != nil {
 			return err
 		}
-		err = tmpBuf.WriteByte(cap.SharedSubscriptionAvailable)
+		err = tmpBuf.WriteByte(cap.SharedSubscriptionAvailable.Value())
 		if err != nil {
 			return err
 		}
@@ -253,9 +253,13 @@ func (cap *ConnectAcknowledgementProperties) Decode(buf []byte) ([]byte, error)
 		case IDReceiveMaximum:
 			cap.ReceiveMaximum, buf, err = decodeUint16(buf)
 		case IDMaximumQoS:
-			cap.MaximumQoS, buf, err = decodeQos(buf)
+			var mq QoS
+			mq, buf, err = decodeQos(buf)
+			cap.MaximumQoS = NewFlagV(mq)
 		case IDRetainAvailable:
-			cap.RetainAvailable, buf, err = decodeByte(buf)
+			var ra byte
+			ra, buf, err = decodeByte(buf)
+			cap.RetainAvailable = NewFlagV(ra)
 		case IDMaximumPacketSize:
 			cap.MaximumPacketSize, buf, err = decodeUint32(buf)
 		case IDAssignedClientID:
@@ -278,11 +282,17 @@ func (cap *ConnectAcknowledgementProperties) Decode(buf []byte) ([]byte, error)
 				}
 			}
 		case IDWildcardSubAvailable:
-			cap.WildcardSubscriptionAvailable, buf, err = decodeByte(buf)
+			var wsa byte
+			wsa, buf, err = decodeByte(buf)
+			cap.WildcardSubscriptionAvailable = NewFlagV(wsa)
 		case IDSubIDAvailable:
-			cap.SubscriptionIdentifierAvailable, buf, err = decodeByte(buf)
+			var sia byte
+			sia, buf, err = decodeByte(buf)
+			cap.SubscriptionIdentifierAvailable = NewFlagV(sia)
 		case IDSharedSubAvailable:
-			cap.SharedSubscriptionAvailable, buf, err = decodeByte(buf)
+			var ssa byte
+			ssa, buf, err = decodeByte(buf)
+			cap.SharedSubscriptionAvailable = NewFlagV(ssa)
 		case IDServerKeepAlive:
 			cap.ServerKeepAlive, buf, err = decodeUint16(buf)
 		case IDResponseInformation:
@@ -304,7 +314,32 @@ func (cap *ConnectAcknowledgementProperties) Decode(buf []byte) ([]byte, error)
 	return buf, nil
 }
 
-func (ca *ConnectAcknowledgement) Decode(buf []byte) error {
+// v3ReturnCodes maps the CONNACK reason codes to the return codes of MQTT 3.1 and 3.1.1.
+// Reason codes without a return code of their own are sent as 0x03 Server unavailable.
+var v3ReturnCodes = map[RCode]byte{
+	RCSuccess:                 0x00,
+	RCUnsupportedProtocol:     0x01,
+	RClientIDNotValid:         0x02,
+	RCServerUnavailable:       0x03,
+	RCServerBusy:              0x03,
+	RCBadUsernameOrPassword:   0x04,
+	RCNotAuthorized:           0x05,
+	RCBanned:                  0x05,
+	RCBadAuthenticationMethod: 0x05,
+}
+
+// v3ReasonCodes maps the return codes of MQTT 3.1 and 3.1.1 back to CONNACK reason codes.
+var v3ReasonCodes = []RCode{RCSuccess, RCUnsupportedProtocol, RClientIDNotValid, RCServerUnavailable, RCBadUsernameOrPassword, RCNotAuthorized}
+
+// v3ReturnCode returns the MQTT 3.1 and 3.1.1 return code of a CONNACK reason code.
+func v3ReturnCode(code RCode) byte {
+	if rc, ok := v3ReturnCodes[code]; ok {
+		return rc
+	}
+	return 0x03
+}
+
+func (ca *ConnectAcknowledgement) Decode(ver ProtocolVersion, buf []byte) error {
 	var err error
 	ca.SessionPresent, buf, err = decodeBool(buf)
 	if err != nil {
@@ -314,6 +349,16 @@ func (ca *ConnectAcknowledgement) Decode(buf []byte) error {
 	if err != nil {
 		return err
 	}
+	if ver != ProtoVer5 {
+		if len(buf) > 0 || int(ca.ConnectReasonCode) >= len(v3ReasonCodes) {
+			return RCMalformedPacket
+		}
+		ca.ConnectReasonCode = v3ReasonCodes[ca.ConnectReasonCode]
+		return nil
+	}
+	if len(buf) == 0 {
+		return nil
+	}
 	ca.Properties = &ConnectAcknowledgementProperties{}
 	buf, err = ca.Properties.Decode(buf)
 	if err != nil {
@@ -324,7 +369,7 @@ func (ca *ConnectAcknowledgement) Decode(buf []byte) error {
 
 func (ca *ConnectAcknowledgement) Encode(ver ProtocolVersion, buf *bytes.Buffer) error {
 	var err error
-	if ca.SessionPresent {
+	if ca.SessionPresent && ver != ProtoVer31 { // the flags of a 3.1 CONNACK are reserved
 		err = buf.WriteByte(1)
 	} else {
 		err = buf.WriteByte(0)
@@ -332,6 +377,9 @@ func (ca *ConnectAcknowledgement) Encode(ver ProtocolVersion, buf *bytes.Buffer)
 	if err != nil {
 		return err
 	}
+	if ver != ProtoVer5 {
+		return buf.WriteByte(v3ReturnCode(ca.ConnectReasonCode))
+	}
 	err = buf.WriteByte(byte(ca.ConnectReasonCode))
 	if err != nil {
 		return err
@@ -345,6 +393,10 @@ func (ca *ConnectAcknowledgement) Encode(ver ProtocolVersion, buf *bytes.Buffer)
 	return nil
 }
 
+func (ca *ConnectAcknowledgement) Type() CPType {
+	return CONNACK
+}
+
 func (ca *ConnectAcknowledgement) Validate() RCode {
 	return RCSuccess
 }
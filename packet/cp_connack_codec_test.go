@@ -37,7 +37,7 @@ func TestConnack(t *testing.T) {
 
 	decodeRunner := func(t *testing.T, tc ConnackCodecTestcase) bool {
 		request := &ConnectAcknowledgement{}
-		err := request.Decode(tc.RequestBytes)
+		err := request.Decode(tc.EncodeVer, tc.RequestBytes)
 		if err != nil {
 			t.Errorf("decode expected \n%v\nbut got \n%v", JSON(tc.Request), err)
 			return false
@@ -73,8 +73,8 @@ var ConnackCodecTestcases = []ConnackCodecTestcase{
 			Properties: &ConnectAcknowledgementProperties{
 				SessionExpiryInterval:    10,
 				ReceiveMaximum:           100,
-				MaximumQoS:               QoS1,
-				RetainAvailable:          1,
+				MaximumQoS:               NewFlagV(QoS1),
+				RetainAvailable:          NewFlagV[uint8](1),
 				MaximumPacketSize:        1024,
 				AssignedClientIdentifier: "id1",
 				TopicAliasMaximum:        10,
@@ -89,9 +89,9 @@ var ConnackCodecTestcases = []ConnackCodecTestcase{
 						Val: "value2",
 					},
 				},
-				WildcardSubscriptionAvailable:   1,
-				SubscriptionIdentifierAvailable: 2,
-				SharedSubscriptionAvailable:     3,
+				WildcardSubscriptionAvailable:   NewFlagV[uint8](1),
+				SubscriptionIdentifierAvailable: NewFlagV[uint8](2),
+				SharedSubscriptionAvailable:     NewFlagV[uint8](3),
 				ServerKeepAlive:                 10,
 				ResponseInformation:             "resp1",
 				ServerReference:                 "ref1",
@@ -101,7 +101,7 @@ var ConnackCodecTestcases = []ConnackCodecTestcase{
 		},
 		RequestBytes: []byte{
 			1,                             // Session Present
-			1,                             // Connect Reason Code
+			byte(RCNormalDisconnection),   // Connect Reason Code
 			108,                           // Properties Length
 			byte(IDSessionExpiryInterval), // Session Expiry Interval ID
 			0, 0, 0, 10,                   // Session Expiry Interval Value
@@ -141,4 +141,63 @@ var ConnackCodecTestcases = []ConnackCodecTestcase{
 			0, 5, 'd', 'a', 't', 'a', '1', // Authentication Data Value
 		},
 	},
+	{
+		Name:      "retain unavailable",
+		EncodeVer: ProtoVer5,
+		Request: &ConnectAcknowledgement{
+			ConnectReasonCode: RCSuccess,
+			Properties: &ConnectAcknowledgementProperties{
+				RetainAvailable: NewFlagV[uint8](0),
+			},
+		},
+		RequestBytes: []byte{
+			0,                       // Session Present
+			byte(RCSuccess),         // Connect Reason Code
+			2,                       // Properties Length
+			byte(IDRetainAvailable), // Retain Available ID
+			0,                       // Retain Available Value
+		},
+	},
+	{
+		Name:      "v3.1.1",
+		EncodeVer: ProtoVer311,
+		Request: &ConnectAcknowledgement{
+			SessionPresent:    true,
+			ConnectReasonCode: RCBadUsernameOrPassword,
+		},
+		RequestBytes: []byte{
+			1,    // Session Present
+			0x04, // Connect Return Code
+		},
+	},
+}
+
+func TestConnackV3ReturnCodes(t *testing.T) {
+	caseList := []struct {
+		rcode  RCode
+		expect byte
+	}{
+		{RCSuccess, 0x00},
+		{RCUnsupportedProtocol, 0x01},
+		{RClientIDNotValid, 0x02},
+		{RCServerBusy, 0x03},
+		{RCBadUsernameOrPassword, 0x04},
+		{RCBanned, 0x05},
+		{RCRetainNotSupported, 0x03},
+	}
+	for _, c := range caseList {
+		buf := bytes.NewBuffer(nil)
+		if err := (&ConnectAcknowledgement{ConnectReasonCode: c.rcode}).Encode(ProtoVer311, buf); err != nil {
+			t.Fatalf("failed to encode: %v", err)
+		}
+		if !bytes.Equal(buf.Bytes(), []byte{0, c.expect}) {
+			t.Errorf("%v: expected return code %d but got %v", c.rcode, c.expect, buf.Bytes())
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	(&ConnectAcknowledgement{SessionPresent: true}).Encode(ProtoVer31, buf)
+	if !bytes.Equal(buf.Bytes(), []byte{0, 0}) {
+		t.Errorf("expected the reserved flags of a 3.1 CONNACK to be 0 but got %v", buf.Bytes())
+	}
 }
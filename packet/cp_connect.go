@@ -323,7 +323,8 @@ func (props *WillProperties) Encode(buf *bytes.Buffer) error {
 	return nil
 }
 
-func (cr *ConnectionRequest) Decode(buf []byte) (err error) {
+// Decode decodes a CONNECT. Its layout follows the protocol version it carries, not the one passed in.
+func (cr *ConnectionRequest) Decode(_ ProtocolVersion, buf []byte) (err error) {
 	cr.ProtocolName, buf, err = decodeBytes(buf)
 	if err != nil {
 		return RCMalformedPacket
@@ -496,8 +497,11 @@ func (cr *ConnectionRequest) Encode(ver ProtocolVersion, buf *bytes.Buffer) erro
 	if cr.Will.Flag() {
 		will := cr.Will.Value()
 		if cr.ProtocolVersion == ProtoVer5 {
-			// todo
-			err = will.Properties.Encode(buf)
+			props := will.Properties
+			if props == nil { // a will without properties still has a zero property length
+				props = &WillProperties{}
+			}
+			err = props.Encode(buf)
 			if err != nil {
 				return err
 			}
@@ -526,6 +530,13 @@ func (cr *ConnectionRequest) Encode(ver ProtocolVersion, buf *bytes.Buffer) erro
 	return nil
 }
 
+// MaxClientIDLengthV31 is the longest client id MQTT 3.1 allows.
+const MaxClientIDLengthV31 = 23
+
+func (cr *ConnectionRequest) Type() CPType {
+	return CONNECT
+}
+
 func (cr *ConnectionRequest) Validate() RCode {
 	// check protocol version
 	if !cr.ProtocolVersion.IsValid() {
@@ -539,8 +550,15 @@ func (cr *ConnectionRequest) Validate() RCode {
 	if cr.Reserved {
 		return RCMalformedPacket
 	}
+	// check the protocol name of the version
+	if !bytes.Equal(cr.ProtocolName, protoVer2ProtocolName[cr.ProtocolVersion]) {
+		return RCUnsupportedProtocol
+	}
 	// check password
 	{
+		if cr.ProtocolVersion != ProtoVer5 && cr.Password.Flag() && !cr.Username.Flag() { // [MQTT-3.1.2-22]
+			return RCMalformedPacket
+		}
 		if cr.Password.Flag() && len(cr.Password.Value()) == 0 {
 			return RCMalformedPacket
 		}
@@ -574,5 +592,8 @@ func (cr *ConnectionRequest) Validate() RCode {
 	if len(cr.ClientID) > math.MaxUint16 {
 		return RCMalformedPacket
 	}
+	if cr.ProtocolVersion == ProtoVer31 && (len(cr.ClientID) == 0 || len(cr.ClientID) > MaxClientIDLengthV31) {
+		return RClientIDNotValid
+	}
 	return RCSuccess
 }
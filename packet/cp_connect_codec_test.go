@@ -37,7 +37,7 @@ func TestConnect(t *testing.T) {
 
 	decodeRunner := func(t *testing.T, tc ConnectCodecTestcase) bool {
 		request := &ConnectionRequest{}
-		err := request.Decode(tc.RequestBytes)
+		err := request.Decode(tc.EncodeVer, tc.RequestBytes)
 		if err != nil {
 			t.Errorf("decode expected \n%v\nbut got \n%v", JSON(tc.Request), err)
 			return false
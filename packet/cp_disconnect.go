@@ -0,0 +1,237 @@
+package packet
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// DISCONNECT – Disconnect notification
+type Disconnect struct {
+	ReasonCode RCode                 `json:"reason_code"`
+	Properties *DisconnectProperties `json:"properties,omitempty"`
+}
+
+type DisconnectProperties struct {
+	SessionExpiryInterval FlagV[uint32]   `json:"session_expiry_interval"`
+	ReasonString          string          `json:"reason_string,omitempty"`
+	ServerReference       string          `json:"server_reference,omitempty"`
+	UserProperty          []*UserProperty `json:"user_property,omitempty"`
+}
+
+// disconnectReasonCodes holds the reason codes a DISCONNECT may carry and the directions they are legal in.
+var disconnectReasonCodes = map[RCode]Direction{
+	RCNormalDisconnection:                 ClientToServer | ServerToClient,
+	RCDisconnectWithWill:                  ClientToServer,
+	RCUnspecifiedError:                    ClientToServer | ServerToClient,
+	RCMalformedPacket:                     ClientToServer | ServerToClient,
+	RCProtocolError:                       ClientToServer | ServerToClient,
+	RCImplementationSpecific:              ClientToServer | ServerToClient,
+	RCNotAuthorized:                       ServerToClient,
+	RCServerBusy:                          ServerToClient,
+	RCServerShuttingDown:                  ServerToClient,
+	RCKeepAliveTimeout:                    ServerToClient,
+	RCSessionTakenOver:                    ServerToClient,
+	RCTopicFilterInvalid:                  ServerToClient,
+	RCTopicNameInvalid:                    ClientToServer | ServerToClient,
+	RCReceiveMaximumExceeded:              ClientToServer | ServerToClient,
+	RCTopicAliasInvalid:                   ClientToServer | ServerToClient,
+	RCPacketTooLarge:                      ClientToServer | ServerToClient,
+	RCMessageRateTooHigh:                  ClientToServer | ServerToClient,
+	RCQuotaExceeded:                       ClientToServer | ServerToClient,
+	RCAdministrativeAction:                ClientToServer | ServerToClient,
+	RCPayloadFormatInvalid:                ClientToServer | ServerToClient,
+	RCRetainNotSupported:                  ServerToClient,
+	RCQoSNotSupported:                     ServerToClient,
+	RCUseAnotherServer:                    ServerToClient,
+	RCServerMoved:                         ServerToClient,
+	RCSharedSubscriptionsNotSupported:     ServerToClient,
+	RCConnectionRateExceeded:              ServerToClient,
+	RCMaximumConnectTime:                  ServerToClient,
+	RCSubscriptionIdentifiersNotSupported: ServerToClient,
+	RCWildcardSubscriptionsNotSupported:   ServerToClient,
+}
+
+func (dp *DisconnectProperties) Encode(buf *bytes.Buffer) error {
+	var err error
+	tmpBuf := bytes.NewBuffer(nil)
+
+	if dp.SessionExpiryInterval.Flag() {
+		err = tmpBuf.WriteByte(byte(IDSessionExpiryInterval))
+		if err != nil {
+			return err
+		}
+		_, err = tmpBuf.Write(encodeUint32(dp.SessionExpiryInterval.Value()))
+		if err != nil {
+			return err
+		}
+	}
+	if dp.ReasonString != "" {
+		err = tmpBuf.WriteByte(byte(IDReasonString))
+		if err != nil {
+			return err
+		}
+		_, err = tmpBuf.Write(encodeString(dp.ReasonString))
+		if err != nil {
+			return err
+		}
+	}
+	if dp.ServerReference != "" {
+		err = tmpBuf.WriteByte(byte(IDServerReference))
+		if err != nil {
+			return err
+		}
+		_, err = tmpBuf.Write(encodeString(dp.ServerReference))
+		if err != nil {
+			return err
+		}
+	}
+	for _, prop := range dp.UserProperty {
+		err = tmpBuf.WriteByte(byte(IDUserProperty))
+		if err != nil {
+			return err
+		}
+		_, err = tmpBuf.Write(encodeString(prop.Key))
+		if err != nil {
+			return err
+		}
+		_, err = tmpBuf.Write(encodeString(prop.Val))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = buf.Write(encodeLength(uint32(tmpBuf.Len())))
+	if err != nil {
+		return err
+	}
+	if tmpBuf.Len() > 0 {
+		_, err = buf.Write(tmpBuf.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (dp *DisconnectProperties) Decode(buf []byte) ([]byte, error) {
+	var length uint32
+	var err error
+	length, buf, err = decodeLength(buf)
+	if err != nil {
+		return buf, err
+	}
+	if length == 0 {
+		return buf, nil
+	}
+	shouldRemain := len(buf) - int(length)
+	for len(buf) > shouldRemain {
+		var id Identifier
+		id, buf, err = decodeIdentifier(buf)
+		if err != nil {
+			return buf, err
+		}
+		switch id {
+		case IDSessionExpiryInterval:
+			var sei uint32
+			sei, buf, err = decodeUint32(buf)
+			if err == nil {
+				dp.SessionExpiryInterval = NewFlagV(sei)
+			}
+		case IDReasonString:
+			dp.ReasonString, buf, err = decodeString(buf)
+		case IDServerReference:
+			dp.ServerReference, buf, err = decodeString(buf)
+		case IDUserProperty:
+			var key, value string
+			key, value, buf, err = decodeStringPair(buf)
+			if err == nil {
+				dp.UserProperty = append(dp.UserProperty, &UserProperty{Key: key, Val: value})
+			}
+		default:
+			err = fmt.Errorf("unknown property id: %d", id)
+		}
+		if err != nil {
+			return buf, err
+		}
+	}
+	return buf, nil
+}
+
+// Decode decodes the variable header. An empty packet is a normal disconnection, which is the only form before v5.
+func (d *Disconnect) Decode(ver ProtocolVersion, buf []byte) error {
+	var err error
+	if len(buf) == 0 { // [MQTT-3.14.2.1]
+		d.ReasonCode = RCNormalDisconnection
+		return nil
+	}
+	if ver != ProtoVer5 {
+		return RCMalformedPacket
+	}
+	d.ReasonCode, buf, err = decodeRCode(buf)
+	if err != nil {
+		return err
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	d.Properties = &DisconnectProperties{}
+	buf, err = d.Properties.Decode(buf)
+	if err != nil {
+		return err
+	}
+	if len(buf) > 0 {
+		return RCMalformedPacket
+	}
+	return nil
+}
+
+func (d *Disconnect) Encode(ver ProtocolVersion, buf *bytes.Buffer) error {
+	if ver != ProtoVer5 {
+		return nil
+	}
+	if d.ReasonCode == RCNormalDisconnection && d.Properties == nil {
+		return nil
+	}
+	err := buf.WriteByte(byte(d.ReasonCode))
+	if err != nil {
+		return err
+	}
+	if d.Properties != nil {
+		err = d.Properties.Encode(buf)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Disconnect) Type() CPType {
+	return DISCONNECT
+}
+
+func (d *Disconnect) Validate() RCode {
+	if _, ok := disconnectReasonCodes[d.ReasonCode]; !ok {
+		return RCProtocolError
+	}
+	return RCSuccess
+}
+
+// ValidateDirection checks that the reason code and properties may be sent in the given direction.
+func (d *Disconnect) ValidateDirection(dir Direction) RCode {
+	if rcode := d.Validate(); rcode != RCSuccess {
+		return rcode
+	}
+	if disconnectReasonCodes[d.ReasonCode]&dir == 0 {
+		return RCProtocolError
+	}
+	if d.Properties == nil {
+		return RCSuccess
+	}
+	if dir == ServerToClient && d.Properties.SessionExpiryInterval.Flag() { // [MQTT-3.14.2-2]
+		return RCProtocolError
+	}
+	if dir == ClientToServer && d.Properties.ServerReference != "" {
+		return RCProtocolError
+	}
+	return RCSuccess
+}
@@ -0,0 +1,172 @@
+package packet
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type DisconnectCodecTestcase struct {
+	Name      string
+	EncodeVer ProtocolVersion
+
+	// data
+	Request      *Disconnect
+	RequestBytes []byte
+}
+
+func TestDisconnect(t *testing.T) {
+	encodeRunner := func(t *testing.T, tc DisconnectCodecTestcase) bool {
+		rcode := tc.Request.Validate()
+		if rcode != RCSuccess {
+			t.Errorf("expected \n%v\nbut got \n%v", RCSuccess, rcode)
+			return false
+		}
+		buf := bytes.NewBuffer(nil)
+		err := tc.Request.Encode(tc.EncodeVer, buf)
+		if err != nil {
+			t.Errorf("expected \n%v\nbut got \n%v", tc.RequestBytes, err)
+			return false
+		}
+		if !bytes.Equal(buf.Bytes(), tc.RequestBytes) {
+			t.Errorf("\nexpected \n%v\ngot \n%v", tc.RequestBytes, buf.Bytes())
+			return false
+		}
+		return true
+	}
+
+	decodeRunner := func(t *testing.T, tc DisconnectCodecTestcase) bool {
+		request := &Disconnect{}
+		err := request.Decode(tc.EncodeVer, tc.RequestBytes)
+		if err != nil {
+			t.Errorf("decode expected \n%v\nbut got \n%v", JSON(tc.Request), err)
+			return false
+		}
+		if !reflect.DeepEqual(tc.Request, request) {
+			t.Errorf("decode expected \n%v\nbut got \n%v", JSON(tc.Request), JSON(request))
+			return false
+		}
+		return true
+	}
+
+	for _, tc := range DisconnectCodecTestcases {
+		t.Run(tc.Name+" decode", func(t *testing.T) {
+			if !decodeRunner(t, tc) {
+				t.FailNow()
+			}
+		})
+		t.Run(tc.Name+" encode", func(t *testing.T) {
+			if !encodeRunner(t, tc) {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+var DisconnectCodecTestcases = []DisconnectCodecTestcase{
+	{
+		Name:         "basic v3.1.1",
+		EncodeVer:    ProtoVer311,
+		Request:      &Disconnect{},
+		RequestBytes: []byte{},
+	},
+	{
+		Name:      "basic v5 with reason code",
+		EncodeVer: ProtoVer5,
+		Request: &Disconnect{
+			ReasonCode: RCDisconnectWithWill,
+		},
+		RequestBytes: []byte{
+			byte(RCDisconnectWithWill), // Reason Code
+		},
+	},
+	{
+		Name:      "basic v5 with full properties",
+		EncodeVer: ProtoVer5,
+		Request: &Disconnect{
+			ReasonCode: RCServerMoved,
+			Properties: &DisconnectProperties{
+				SessionExpiryInterval: NewFlagV[uint32](10),
+				ReasonString:          "moved",
+				ServerReference:       "ref1",
+				UserProperty: []*UserProperty{
+					{Key: "user1", Val: "value1"},
+				},
+			},
+		},
+		RequestBytes: []byte{
+			byte(RCServerMoved),           // Reason Code
+			36,                            // Properties Length
+			byte(IDSessionExpiryInterval), // Session Expiry Interval ID
+			0, 0, 0, 10,                   // Session Expiry Interval Value
+			byte(IDReasonString),          // Reason String ID
+			0, 5, 'm', 'o', 'v', 'e', 'd', // Reason String Value
+			byte(IDServerReference),  // Server Reference ID
+			0, 4, 'r', 'e', 'f', '1', // Server Reference Value
+			byte(IDUserProperty),                                              // User Property ID
+			0, 5, 'u', 's', 'e', 'r', '1', 0, 6, 'v', 'a', 'l', 'u', 'e', '1', // User Property 1
+		},
+	},
+}
+
+func TestDisconnectValidateDirection(t *testing.T) {
+	caseList := []struct {
+		name   string
+		packet *Disconnect
+		dir    Direction
+		expect RCode
+	}{
+		{
+			name:   "normal from client",
+			packet: &Disconnect{ReasonCode: RCNormalDisconnection},
+			dir:    ClientToServer,
+			expect: RCSuccess,
+		},
+		{
+			name:   "will from client",
+			packet: &Disconnect{ReasonCode: RCDisconnectWithWill},
+			dir:    ClientToServer,
+			expect: RCSuccess,
+		},
+		{
+			name:   "will from server",
+			packet: &Disconnect{ReasonCode: RCDisconnectWithWill},
+			dir:    ServerToClient,
+			expect: RCProtocolError,
+		},
+		{
+			name:   "session taken over from client",
+			packet: &Disconnect{ReasonCode: RCSessionTakenOver},
+			dir:    ClientToServer,
+			expect: RCProtocolError,
+		},
+		{
+			name:   "session taken over from server",
+			packet: &Disconnect{ReasonCode: RCSessionTakenOver},
+			dir:    ServerToClient,
+			expect: RCSuccess,
+		},
+		{
+			name: "session expiry interval from server",
+			packet: &Disconnect{
+				ReasonCode: RCServerShuttingDown,
+				Properties: &DisconnectProperties{SessionExpiryInterval: NewFlagV[uint32](1)},
+			},
+			dir:    ServerToClient,
+			expect: RCProtocolError,
+		},
+		{
+			name:   "not a disconnect reason code",
+			packet: &Disconnect{ReasonCode: RCGrantedQoS2},
+			dir:    ClientToServer,
+			expect: RCProtocolError,
+		},
+	}
+	for _, c := range caseList {
+		t.Run(c.name, func(t *testing.T) {
+			if rcode := c.packet.ValidateDirection(c.dir); rcode != c.expect {
+				t.Errorf("expected %v but got %v", c.expect, rcode)
+			}
+		})
+	}
+}
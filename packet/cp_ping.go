@@ -0,0 +1,47 @@
+package packet
+
+import "bytes"
+
+// PINGREQ – PING request
+type PingRequest struct{}
+
+func (pr *PingRequest) Decode(ver ProtocolVersion, buf []byte) error {
+	if len(buf) > 0 { // [MQTT-3.12.3]
+		return RCMalformedPacket
+	}
+	return nil
+}
+
+func (pr *PingRequest) Encode(ver ProtocolVersion, buf *bytes.Buffer) error {
+	return nil
+}
+
+func (pr *PingRequest) Type() CPType {
+	return PINGREQ
+}
+
+func (pr *PingRequest) Validate() RCode {
+	return RCSuccess
+}
+
+// PINGRESP – PING response
+type PingResponse struct{}
+
+func (pr *PingResponse) Decode(ver ProtocolVersion, buf []byte) error {
+	if len(buf) > 0 { // [MQTT-3.13.3]
+		return RCMalformedPacket
+	}
+	return nil
+}
+
+func (pr *PingResponse) Encode(ver ProtocolVersion, buf *bytes.Buffer) error {
+	return nil
+}
+
+func (pr *PingResponse) Type() CPType {
+	return PINGRESP
+}
+
+func (pr *PingResponse) Validate() RCode {
+	return RCSuccess
+}
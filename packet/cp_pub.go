@@ -3,6 +3,7 @@ package packet
 import (
 	"bytes"
 	"fmt"
+	"unicode/utf8"
 )
 
 type PublishMessage struct {
@@ -25,7 +26,7 @@ type PublishMessageProperties struct {
 	ResponseTopic          string                 `json:"response_topic"`
 	CorrelationData        []byte                 `json:"correlation_data"`
 	UserProperty           []*UserProperty        `json:"user_property"`
-	SubscriptionIdentifier []byte                 `json:"subscription_identifier"`
+	SubscriptionIdentifier []int                  `json:"subscription_identifier"`
 	ContentType            string                 `json:"content_type"`
 }
 
@@ -100,12 +101,12 @@ func (pmp *PublishMessageProperties) Encode(buf *bytes.Buffer) error {
 			}
 		}
 	}
-	if pmp.SubscriptionIdentifier != nil {
+	for _, id := range pmp.SubscriptionIdentifier {
 		err = tmpBuf.WriteByte(byte(IDSubscriptionIdentifier))
 		if err != nil {
 			return err
 		}
-		_, err = tmpBuf.Write(encodeBytes(pmp.SubscriptionIdentifier))
+		_, err = tmpBuf.Write(encodeVarint(int32(id)))
 		if err != nil {
 			return err
 		}
@@ -162,6 +163,9 @@ func (pmp *PublishMessageProperties) Decode(buf []byte) ([]byte, error) {
 			pmp.MessageExpiryInterval, buf, err = decodeUint32(buf)
 		case IDTopicAlias:
 			pmp.TopicAlias, buf, err = decodeUint16(buf)
+			if err == nil && pmp.TopicAlias == 0 { // a Topic Alias of 0 is not permitted
+				err = RCTopicAliasInvalid
+			}
 		case IDResponseTopic:
 			pmp.ResponseTopic, buf, err = decodeString(buf)
 		case IDCorrelationData:
@@ -177,7 +181,12 @@ func (pmp *PublishMessageProperties) Decode(buf []byte) ([]byte, error) {
 				}
 			}
 		case IDSubscriptionIdentifier:
-			pmp.SubscriptionIdentifier, buf, err = decodeBytes(buf)
+			var id int32
+			id, buf, err = decodeVarint(buf)
+			if err == nil && id == 0 { // a Subscription Identifier of 0 is a Protocol Error
+				err = RCProtocolError
+			}
+			pmp.SubscriptionIdentifier = append(pmp.SubscriptionIdentifier, int(id))
 		case IDContentType:
 			pmp.ContentType, buf, err = decodeString(buf)
 		default:
@@ -191,23 +200,28 @@ func (pmp *PublishMessageProperties) Decode(buf []byte) ([]byte, error) {
 	return buf, nil
 }
 
-func (pm *PublishMessage) Decode(buf []byte) error {
+// Decode decodes a PUBLISH. The QoS must already be set from the fixed header flags.
+func (pm *PublishMessage) Decode(ver ProtocolVersion, buf []byte) error {
 	var err error
 	pm.TopicName, buf, err = decodeString(buf)
 	if err != nil {
 		return err
 	}
-	pm.PacketID, buf, err = decodeUint16(buf)
-	if err != nil {
-		return err
+	if pm.QoSLevel > QoS0 { // [MQTT-2.2.1-2]
+		pm.PacketID, buf, err = decodeUint16(buf)
+		if err != nil {
+			return err
+		}
 	}
-	buf, err = pm.Properties.Decode(buf)
-	if err != nil {
-		return err
+	if ver == ProtoVer5 {
+		buf, err = pm.Properties.Decode(buf)
+		if err != nil {
+			return err
+		}
 	}
-	pm.Payload, buf, err = decodeBytes(buf)
-	if err != nil {
-		return err
+	// the payload is the remainder of the packet [MQTT-3.3.3]
+	if len(buf) > 0 {
+		pm.Payload = buf
 	}
 	return nil
 }
@@ -218,21 +232,82 @@ func (pm *PublishMessage) Encode(ver ProtocolVersion, buf *bytes.Buffer) error {
 	if err != nil {
 		return err
 	}
-	_, err = buf.Write(encodeUint16(pm.PacketID))
-	if err != nil {
-		return err
+	if pm.QoSLevel > QoS0 {
+		_, err = buf.Write(encodeUint16(pm.PacketID))
+		if err != nil {
+			return err
+		}
 	}
-	err = pm.Properties.Encode(buf)
-	if err != nil {
-		return err
+	if ver == ProtoVer5 {
+		err = pm.Properties.Encode(buf)
+		if err != nil {
+			return err
+		}
 	}
-	_, err = buf.Write(encodeBytes(pm.Payload))
+	_, err = buf.Write(pm.Payload)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// flags returns the DUP, QoS and RETAIN bits of the fixed header.
+func (pm *PublishMessage) flags() byte {
+	var flags byte
+	if pm.DUP {
+		flags |= 0x08
+	}
+	flags |= byte(pm.QoSLevel&0x03) << 1
+	if pm.Retain {
+		flags |= 0x01
+	}
+	return flags
+}
+
+// setFlags sets the DUP, QoS and RETAIN fields from the fixed header.
+func (pm *PublishMessage) setFlags(flags byte) {
+	pm.DUP = flags&0x08 > 0
+	pm.QoSLevel = QoS(flags >> 1 & 0x03)
+	pm.Retain = flags&0x01 > 0
+}
+
+func (pm *PublishMessage) Type() CPType {
+	return PUBLISH
+}
+
 func (pm *PublishMessage) Validate() RCode {
+	if !pm.QoSLevel.IsValid() { // [MQTT-3.3.1-4]
+		return RCMalformedPacket
+	}
+	if pm.QoSLevel == QoS0 && pm.DUP { // [MQTT-3.3.1-2]
+		return RCMalformedPacket
+	}
+	if pm.QoSLevel > QoS0 && pm.PacketID == 0 { // [MQTT-2.2.1-3]
+		return RCMalformedPacket
+	}
+	if len(pm.TopicName) == 0 {
+		if pm.Properties.TopicAlias == 0 { // [MQTT-3.3.2-1]
+			return RCProtocolError
+		}
+	} else if !ValidTopicName(pm.TopicName) { // [MQTT-3.3.2-2]
+		return RCTopicNameInvalid
+	}
+	switch pm.Properties.PayloadFormatIndicator {
+	case PFI_BYTE:
+	case PFI_UTF8:
+		if !utf8.Valid(pm.Payload) { // [MQTT-3.3.2-4]
+			return RCPayloadFormatInvalid
+		}
+	default:
+		return RCProtocolError
+	}
+	return RCSuccess
+}
+
+// ValidateTopicAlias checks the topic alias against the Topic Alias Maximum of the receiver. [MQTT-3.3.2-9] [MQTT-3.3.2-10]
+func (pm *PublishMessage) ValidateTopicAlias(maximum uint16) RCode {
+	if pm.Properties.TopicAlias > maximum {
+		return RCTopicAliasInvalid
+	}
 	return RCSuccess
 }
@@ -37,7 +37,8 @@ func TestPub(t *testing.T) {
 
 	decodeRunner := func(t *testing.T, tc PubCodecTestcase) bool {
 		request := &PublishMessage{}
-		err := request.Decode(tc.RequestBytes)
+		request.setFlags(tc.Request.flags())
+		err := request.Decode(tc.EncodeVer, tc.RequestBytes)
 		if err != nil {
 			t.Errorf("decode expected \n%v\nbut got \n%v", JSON(tc.Request), err)
 			return false
@@ -68,6 +69,7 @@ var PubCodecTestcases = []PubCodecTestcase{
 		Name:      "basic",
 		EncodeVer: ProtoVer5,
 		Request: &PublishMessage{
+			QoSLevel:  QoS1,
 			TopicName: "topic",
 			PacketID:  1,
 			Payload:   []byte("payload"),
@@ -82,13 +84,13 @@ var PubCodecTestcases = []PubCodecTestcase{
 					{Key: "user1", Val: "value1"},
 					{Key: "user2", Val: "value2"},
 				},
-				SubscriptionIdentifier: []byte("subscription"),
+				SubscriptionIdentifier: []int{1, 300},
 			},
 		},
 		RequestBytes: []byte{
 			0, 5, 't', 'o', 'p', 'i', 'c', // Topic Name
 			0, 1, // Packet ID
-			100, // Properties Length
+			90, // Properties Length
 			byte(IDPayloadFormatIndicator),
 			1, // Payload Format Indicator
 			byte(IDMessageExpiryInterval),
@@ -104,10 +106,53 @@ var PubCodecTestcases = []PubCodecTestcase{
 			byte(IDUserProperty),
 			0, 5, 'u', 's', 'e', 'r', '2', 0, 6, 'v', 'a', 'l', 'u', 'e', '2', // User Property
 			byte(IDSubscriptionIdentifier),
-			0, 12, 's', 'u', 'b', 's', 'c', 'r', 'i', 'p', 't', 'i', 'o', 'n', // Subscription Identifier
+			1, // Subscription Identifier
+			byte(IDSubscriptionIdentifier),
+			0xAC, 0x02, // Subscription Identifier
 			byte(IDContentType),
 			0, 10, 't', 'e', 'x', 't', '/', 'p', 'l', 'a', 'i', 'n', // Content Type
-			0, 7, 'p', 'a', 'y', 'l', 'o', 'a', 'd', // Payload
+			'p', 'a', 'y', 'l', 'o', 'a', 'd', // Payload
+		},
+	},
+	{
+		Name:      "qos0 v5",
+		EncodeVer: ProtoVer5,
+		Request: &PublishMessage{
+			Retain:    true,
+			TopicName: "topic",
+			Payload:   []byte("payload"),
+		},
+		RequestBytes: []byte{
+			0, 5, 't', 'o', 'p', 'i', 'c', // Topic Name
+			0,                                 // Properties Length
+			'p', 'a', 'y', 'l', 'o', 'a', 'd', // Payload
+		},
+	},
+	{
+		Name:      "qos2 v3.1.1",
+		EncodeVer: ProtoVer311,
+		Request: &PublishMessage{
+			DUP:       true,
+			QoSLevel:  QoS2,
+			TopicName: "topic",
+			PacketID:  10,
+			Payload:   []byte("payload"),
+		},
+		RequestBytes: []byte{
+			0, 5, 't', 'o', 'p', 'i', 'c', // Topic Name
+			0, 10, // Packet ID
+			'p', 'a', 'y', 'l', 'o', 'a', 'd', // Payload
+		},
+	},
+	{
+		Name:      "empty payload v3.1",
+		EncodeVer: ProtoVer31,
+		Request: &PublishMessage{
+			Retain:    true,
+			TopicName: "topic",
+		},
+		RequestBytes: []byte{
+			0, 5, 't', 'o', 'p', 'i', 'c', // Topic Name
 		},
 	},
 }
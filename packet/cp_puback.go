@@ -10,43 +10,39 @@ type PublishAcknowledgement struct {
 	Properties BaseProperties
 }
 
-func (pa *PublishAcknowledgement) Decode(buf []byte) error {
-	var err error
-	pa.PacketID, buf, err = decodeUint16(buf)
-	if err != nil {
-		return err
-	}
-	var code byte
-	code, buf, err = decodeByte(buf)
-	if err != nil {
-		return err
-	}
-	pa.ReasonCode = RCode(code)
+// pubackReasonCodes holds the reason codes a PUBACK or PUBREC may carry.
+var pubackReasonCodes = map[RCode]bool{
+	RCSuccess:                true,
+	RCNoMatchingSubscribers:  true,
+	RCUnspecifiedError:       true,
+	RCImplementationSpecific: true,
+	RCNotAuthorized:          true,
+	RCTopicNameInvalid:       true,
+	RCPacketIDInUse:          true,
+	RCQuotaExceeded:          true,
+	RCPayloadFormatInvalid:   true,
+}
 
-	buf, err = pa.Properties.Decode(buf)
-	if err != nil {
-		return err
-	}
-	return nil
+func (pa *PublishAcknowledgement) Decode(ver ProtocolVersion, buf []byte) error {
+	var err error
+	pa.PacketID, pa.ReasonCode, err = decodeAck(ver, buf, &pa.Properties)
+	return err
 }
 
 func (pa *PublishAcknowledgement) Encode(ver ProtocolVersion, buf *bytes.Buffer) error {
-	var err error
-	_, err = buf.Write(encodeUint16(pa.PacketID))
-	if err != nil {
-		return err
-	}
-	err = buf.WriteByte(byte(pa.ReasonCode))
-	if err != nil {
-		return err
-	}
-	err = pa.Properties.Encode(buf)
-	if err != nil {
-		return err
-	}
-	return nil
+	return encodeAck(ver, buf, pa.PacketID, pa.ReasonCode, &pa.Properties)
+}
+
+func (pa *PublishAcknowledgement) Type() CPType {
+	return PUBACK
 }
 
 func (pa *PublishAcknowledgement) Validate() RCode {
+	if pa.PacketID == 0 { // [MQTT-2.2.1-3]
+		return RCMalformedPacket
+	}
+	if !pubackReasonCodes[pa.ReasonCode] {
+		return RCProtocolError
+	}
 	return RCSuccess
 }
@@ -37,7 +37,7 @@ func TestPubAck(t *testing.T) {
 
 	decodeRunner := func(t *testing.T, tc PubAckCodecTestcase) bool {
 		request := &PublishAcknowledgement{}
-		err := request.Decode(tc.RequestBytes)
+		err := request.Decode(tc.EncodeVer, tc.RequestBytes)
 		if err != nil {
 			t.Errorf("decode expected \n%v\nbut got \n%v", JSON(tc.Request), err)
 			return false
@@ -90,4 +90,24 @@ var PubAckCodecTestcases = []PubAckCodecTestcase{
 			0, 5, 'u', 's', 'e', 'r', '2', 0, 6, 'v', 'a', 'l', 'u', 'e', '2', // User Property 2
 		},
 	},
+	{
+		Name:      "v3.1.1",
+		EncodeVer: ProtoVer311,
+		Request: &PublishAcknowledgement{
+			PacketID: 10,
+		},
+		RequestBytes: []byte{
+			0, 10, // Packet ID
+		},
+	},
+	{
+		Name:      "success without properties",
+		EncodeVer: ProtoVer5,
+		Request: &PublishAcknowledgement{
+			PacketID: 10,
+		},
+		RequestBytes: []byte{
+			0, 10, // Packet ID, the reason code and properties are omitted
+		},
+	},
 }
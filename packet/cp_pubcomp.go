@@ -8,43 +8,26 @@ type PublishComplete struct {
 	Properties BaseProperties
 }
 
-func (pa *PublishComplete) Decode(buf []byte) error {
+func (pa *PublishComplete) Decode(ver ProtocolVersion, buf []byte) error {
 	var err error
-	pa.PacketID, buf, err = decodeUint16(buf)
-	if err != nil {
-		return err
-	}
-	var code byte
-	code, buf, err = decodeByte(buf)
-	if err != nil {
-		return err
-	}
-	pa.ReasonCode = RCode(code)
-
-	buf, err = pa.Properties.Decode(buf)
-	if err != nil {
-		return err
-	}
-	return nil
+	pa.PacketID, pa.ReasonCode, err = decodeAck(ver, buf, &pa.Properties)
+	return err
 }
 
 func (pa *PublishComplete) Encode(ver ProtocolVersion, buf *bytes.Buffer) error {
-	var err error
-	_, err = buf.Write(encodeUint16(pa.PacketID))
-	if err != nil {
-		return err
-	}
-	err = buf.WriteByte(byte(pa.ReasonCode))
-	if err != nil {
-		return err
-	}
-	err = pa.Properties.Encode(buf)
-	if err != nil {
-		return err
-	}
-	return nil
+	return encodeAck(ver, buf, pa.PacketID, pa.ReasonCode, &pa.Properties)
+}
+
+func (pa *PublishComplete) Type() CPType {
+	return PUBCOMP
 }
 
 func (pa *PublishComplete) Validate() RCode {
+	if pa.PacketID == 0 { // [MQTT-2.2.1-3]
+		return RCMalformedPacket
+	}
+	if !pubrelReasonCodes[pa.ReasonCode] {
+		return RCProtocolError
+	}
 	return RCSuccess
 }
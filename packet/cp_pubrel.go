@@ -8,43 +8,32 @@ type PublishRelease struct {
 	Properties BaseProperties
 }
 
-func (pa *PublishRelease) Decode(buf []byte) error {
-	var err error
-	pa.PacketID, buf, err = decodeUint16(buf)
-	if err != nil {
-		return err
-	}
-	var code byte
-	code, buf, err = decodeByte(buf)
-	if err != nil {
-		return err
-	}
-	pa.ReasonCode = RCode(code)
+// pubrelReasonCodes holds the reason codes a PUBREL or PUBCOMP may carry.
+var pubrelReasonCodes = map[RCode]bool{
+	RCSuccess:          true,
+	RCPacketIDNotFound: true,
+}
 
-	buf, err = pa.Properties.Decode(buf)
-	if err != nil {
-		return err
-	}
-	return nil
+func (pa *PublishRelease) Decode(ver ProtocolVersion, buf []byte) error {
+	var err error
+	pa.PacketID, pa.ReasonCode, err = decodeAck(ver, buf, &pa.Properties)
+	return err
 }
 
 func (pa *PublishRelease) Encode(ver ProtocolVersion, buf *bytes.Buffer) error {
-	var err error
-	_, err = buf.Write(encodeUint16(pa.PacketID))
-	if err != nil {
-		return err
-	}
-	err = buf.WriteByte(byte(pa.ReasonCode))
-	if err != nil {
-		return err
-	}
-	err = pa.Properties.Encode(buf)
-	if err != nil {
-		return err
-	}
-	return nil
+	return encodeAck(ver, buf, pa.PacketID, pa.ReasonCode, &pa.Properties)
+}
+
+func (pa *PublishRelease) Type() CPType {
+	return PUBREL
 }
 
 func (pa *PublishRelease) Validate() RCode {
+	if pa.PacketID == 0 { // [MQTT-2.2.1-3]
+		return RCMalformedPacket
+	}
+	if !pubrelReasonCodes[pa.ReasonCode] {
+		return RCProtocolError
+	}
 	return RCSuccess
 }
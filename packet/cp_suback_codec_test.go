@@ -0,0 +1,115 @@
+package packet
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type SubAckCodecTestcase struct {
+	Name      string
+	EncodeVer ProtocolVersion
+
+	// data
+	Request      *SubscribeAcknowledgement
+	RequestBytes []byte
+}
+
+func TestSubAck(t *testing.T) {
+	encodeRunner := func(t *testing.T, tc SubAckCodecTestcase) bool {
+		rcode := tc.Request.Validate()
+		if rcode != RCSuccess {
+			t.Errorf("expected \n%v\nbut got \n%v", RCSuccess, rcode)
+			return false
+		}
+		buf := bytes.NewBuffer(nil)
+		err := tc.Request.Encode(tc.EncodeVer, buf)
+		if err != nil {
+			t.Errorf("expected \n%v\nbut got \n%v", tc.RequestBytes, err)
+			return false
+		}
+		if !bytes.Equal(buf.Bytes(), tc.RequestBytes) {
+			t.Errorf("\nexpected \n%v\ngot \n%v", tc.RequestBytes, buf.Bytes())
+			return false
+		}
+		return true
+	}
+
+	decodeRunner := func(t *testing.T, tc SubAckCodecTestcase) bool {
+		request := &SubscribeAcknowledgement{}
+		err := request.Decode(tc.EncodeVer, tc.RequestBytes)
+		if err != nil {
+			t.Errorf("decode expected \n%v\nbut got \n%v", JSON(tc.Request), err)
+			return false
+		}
+		if !reflect.DeepEqual(tc.Request, request) {
+			t.Errorf("decode expected \n%v\nbut got \n%v", JSON(tc.Request), JSON(request))
+			return false
+		}
+		return true
+	}
+
+	for _, tc := range SubAckCodecTestcases {
+		t.Run(tc.Name+" decode", func(t *testing.T) {
+			if !decodeRunner(t, tc) {
+				t.FailNow()
+			}
+		})
+		t.Run(tc.Name+" encode", func(t *testing.T) {
+			if !encodeRunner(t, tc) {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+var SubAckCodecTestcases = []SubAckCodecTestcase{
+	{
+		Name:      "basic",
+		EncodeVer: ProtoVer5,
+		Request: &SubscribeAcknowledgement{
+			PacketID: 10,
+			Properties: BaseProperties{
+				ReasonString: "reason",
+				UserProperty: []*UserProperty{
+					{Key: "user1", Val: "value1"},
+				},
+			},
+			ReasonCodes: []RCode{RCGrantedQoS0, RCGrantedQoS2, RCNotAuthorized},
+		},
+		RequestBytes: []byte{
+			0, 10, // Packet ID
+			25,                                 // Properties Length
+			byte(IDReasonString),               // Reason String ID
+			0, 6, 'r', 'e', 'a', 's', 'o', 'n', // Reason String Value
+			byte(IDUserProperty),                                              // User Property ID
+			0, 5, 'u', 's', 'e', 'r', '1', 0, 6, 'v', 'a', 'l', 'u', 'e', '1', // User Property 1
+			byte(RCGrantedQoS0),   // Reason Code 1
+			byte(RCGrantedQoS2),   // Reason Code 2
+			byte(RCNotAuthorized), // Reason Code 3
+		},
+	},
+	{
+		Name:      "v3.1.1",
+		EncodeVer: ProtoVer311,
+		Request: &SubscribeAcknowledgement{
+			PacketID:    10,
+			ReasonCodes: []RCode{RCGrantedQoS0, RCGrantedQoS1, RCGrantedQoS2, RCUnspecifiedError},
+		},
+		RequestBytes: []byte{
+			0, 10, // Packet ID
+			0x00, 0x01, 0x02, 0x80, // Return Codes
+		},
+	},
+}
+
+func TestSubAckV3FailureCodes(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	sa := &SubscribeAcknowledgement{PacketID: 1, ReasonCodes: []RCode{RCGrantedQoS1, RCNotAuthorized, RCTopicFilterInvalid}}
+	if err := sa.Encode(ProtoVer311, buf); err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	if expect := []byte{0, 1, 0x01, 0x80, 0x80}; !bytes.Equal(buf.Bytes(), expect) {
+		t.Errorf("expected %v but got %v", expect, buf.Bytes())
+	}
+}
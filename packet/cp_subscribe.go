@@ -6,7 +6,7 @@ import (
 )
 
 type SubscribeRequest struct {
-	ClientID   string
+	PacketID   uint16
 	Properties SubscribeRequestProperties
 	Payload    []*SubscribePayload
 }
@@ -121,18 +121,24 @@ type SubscribePayload struct {
 	RetainHandling    RetainHandling
 }
 
-func (sp *SubscribePayload) Decode(subscriptionID int, buf []byte) ([]byte, error) {
+func (sp *SubscribePayload) Decode(ver ProtocolVersion, subscriptionID int, buf []byte) ([]byte, error) {
 	var err error
 	sp.TopicFilter, buf, err = decodeString(buf)
 	if err != nil {
 		return buf, err
 	}
-	// todo 增加ProtocolVersion5校验
 	var b byte
 	b, buf, err = decodeByte(buf)
 	if err != nil {
 		return buf, err
 	}
+	reserved := byte(0xC0) // [MQTT-3.8.3-5]
+	if ver != ProtoVer5 {  // only the QoS is set before v5
+		reserved = 0xFC
+	}
+	if b&reserved != 0 {
+		return buf, RCMalformedPacket
+	}
 	sp.SubscriptionID = subscriptionID
 
 	sp.QoS = QoS(b & 3)                              // QoS
@@ -143,7 +149,7 @@ func (sp *SubscribePayload) Decode(subscriptionID int, buf []byte) ([]byte, erro
 	return buf, nil
 }
 
-func (sp *SubscribePayload) Encode(buf *bytes.Buffer) error {
+func (sp *SubscribePayload) Encode(ver ProtocolVersion, buf *bytes.Buffer) error {
 	var err error
 	_, err = buf.Write(encodeString(sp.TopicFilter))
 	if err != nil {
@@ -152,6 +158,9 @@ func (sp *SubscribePayload) Encode(buf *bytes.Buffer) error {
 
 	var flag byte
 	flag |= byte(sp.QoS)
+	if ver != ProtoVer5 {
+		return buf.WriteByte(flag)
+	}
 
 	if sp.NoLocal {
 		flag |= 1 << 2
@@ -170,6 +179,25 @@ func (sp *SubscribePayload) Encode(buf *bytes.Buffer) error {
 	return nil
 }
 
+func (sp *SubscribePayload) Validate() RCode {
+	if !sp.QoS.IsValid() { // [MQTT-3.8.3-5]
+		return RCMalformedPacket
+	}
+	if sp.RetainHandling > RetainHandlingDoNotSend {
+		return RCProtocolError
+	}
+	if sp.SubscriptionID < 0 || sp.SubscriptionID > MaxRemainingLength {
+		return RCProtocolError
+	}
+	if !ValidTopicFilter(sp.TopicFilter) {
+		return RCTopicFilterInvalid
+	}
+	if sp.NoLocal && IsSharedSubscription(sp.TopicFilter) { // [MQTT-3.8.3-4]
+		return RCProtocolError
+	}
+	return RCSuccess
+}
+
 type RetainHandling byte
 
 const (
@@ -178,46 +206,57 @@ const (
 	RetainHandlingDoNotSend        RetainHandling = 0x02 // Do not send retained messages at the time of the subscribe
 )
 
-func (sr *SubscribeRequest) Decode(buf []byte) error {
+func (sr *SubscribeRequest) Decode(ver ProtocolVersion, buf []byte) error {
 	var err error
-	sr.ClientID, buf, err = decodeString(buf)
+	sr.PacketID, buf, err = decodeUint16(buf)
 	if err != nil {
 		return err
 	}
 	var subscriptionIDs []int
-	subscriptionIDs, buf, err = sr.Properties.Decode(buf)
-	if err != nil {
-		return err
+	if ver == ProtoVer5 {
+		subscriptionIDs, buf, err = sr.Properties.Decode(buf)
+		if err != nil {
+			return err
+		}
+	}
+	if len(subscriptionIDs) > 1 { // [MQTT-3.8.2.1.2]
+		return RCProtocolError
+	}
+	// the subscription identifier applies to every topic filter of the request
+	var subscriptionID int
+	if len(subscriptionIDs) == 1 {
+		subscriptionID = subscriptionIDs[0]
 	}
 	sr.Payload = make([]*SubscribePayload, 0)
-	idx := 0
 	for len(buf) > 0 {
 		var payload = &SubscribePayload{}
-		buf, err = payload.Decode(subscriptionIDs[idx], buf)
+		buf, err = payload.Decode(ver, subscriptionID, buf)
 		if err != nil {
 			return err
 		}
 		sr.Payload = append(sr.Payload, payload)
-		idx++
 	}
 	return nil
 }
 
 func (sr *SubscribeRequest) Encode(ver ProtocolVersion, buf *bytes.Buffer) error {
 	var err error
-	_, err = buf.Write(encodeString(sr.ClientID))
+	_, err = buf.Write(encodeUint16(sr.PacketID))
 	if err != nil {
 		return err
 	}
-	ids := extractValues(sr.Payload, func(payload *SubscribePayload) int {
-		return payload.SubscriptionID
-	})
-	err = sr.Properties.Encode(buf, ids)
-	if err != nil {
-		return err
+	if ver == ProtoVer5 {
+		var ids []int
+		if len(sr.Payload) > 0 && sr.Payload[0].SubscriptionID > 0 {
+			ids = []int{sr.Payload[0].SubscriptionID}
+		}
+		err = sr.Properties.Encode(buf, ids)
+		if err != nil {
+			return err
+		}
 	}
 	for _, payload := range sr.Payload {
-		err = payload.Encode(buf)
+		err = payload.Encode(ver, buf)
 		if err != nil {
 			return err
 		}
@@ -225,6 +264,25 @@ func (sr *SubscribeRequest) Encode(ver ProtocolVersion, buf *bytes.Buffer) error
 	return nil
 }
 
+func (sr *SubscribeRequest) Type() CPType {
+	return SUBSCRIBE
+}
+
 func (sr *SubscribeRequest) Validate() RCode {
+	if sr.PacketID == 0 { // [MQTT-2.2.1-3]
+		return RCMalformedPacket
+	}
+	if len(sr.Payload) == 0 { // [MQTT-3.8.3-2]
+		return RCProtocolError
+	}
+	subscriptionID := sr.Payload[0].SubscriptionID
+	for _, payload := range sr.Payload {
+		if payload.SubscriptionID != subscriptionID {
+			return RCProtocolError
+		}
+		if rcode := payload.Validate(); rcode != RCSuccess {
+			return rcode
+		}
+	}
 	return RCSuccess
 }
@@ -37,7 +37,7 @@ func TestSubscribe(t *testing.T) {
 
 	decodeRunner := func(t *testing.T, tc SubscribeCodecTestcase) bool {
 		request := &SubscribeRequest{}
-		err := request.Decode(tc.RequestBytes)
+		err := request.Decode(tc.EncodeVer, tc.RequestBytes)
 		if err != nil {
 			t.Errorf("decode expected \n%v\nbut got \n%v", JSON(tc.Request), err)
 			return false
@@ -68,7 +68,7 @@ var SubscribeCodecTestcases = []SubscribeCodecTestcase{
 		Name:      "basic",
 		EncodeVer: ProtoVer5,
 		Request: &SubscribeRequest{
-			ClientID: "clientID",
+			PacketID: 10,
 			Properties: SubscribeRequestProperties{
 				UserProperty: []*UserProperty{
 					{Key: "user1", Val: "value1"},
@@ -87,8 +87,7 @@ var SubscribeCodecTestcases = []SubscribeCodecTestcase{
 			},
 		},
 		RequestBytes: []byte{
-			0, 8, // Client ID
-			'c', 'l', 'i', 'e', 'n', 't', 'I', 'D',
+			0, 10, // Packet ID
 			34,                                                                // properties length
 			byte(IDSubscriptionIdentifier),                                    // Subscription Identifier ID
 			1,                                                                 // Subscription Identifier Value
@@ -101,4 +100,19 @@ var SubscribeCodecTestcases = []SubscribeCodecTestcase{
 			29, // flags
 		},
 	},
+	{
+		Name:      "v3.1.1",
+		EncodeVer: ProtoVer311,
+		Request: &SubscribeRequest{
+			PacketID: 10,
+			Payload: []*SubscribePayload{
+				{TopicFilter: "a/b", QoS: QoS1},
+			},
+		},
+		RequestBytes: []byte{
+			0, 10, // Packet ID
+			0, 3, 'a', '/', 'b', // Topic Filter
+			1, // Requested QoS
+		},
+	},
 }
@@ -0,0 +1,89 @@
+package packet
+
+import (
+	"bytes"
+)
+
+type UnsubscribeAcknowledgement struct {
+	PacketID    uint16
+	Properties  BaseProperties
+	ReasonCodes []RCode
+}
+
+// unsubackReasonCodes holds the reason codes an UNSUBACK may carry.
+var unsubackReasonCodes = map[RCode]bool{
+	RCSuccess:                true,
+	RCNoSubscriptionExisted:  true,
+	RCUnspecifiedError:       true,
+	RCImplementationSpecific: true,
+	RCNotAuthorized:          true,
+	RCTopicFilterInvalid:     true,
+	RCPacketIDInUse:          true,
+}
+
+// Decode decodes an UNSUBACK. Before v5 it only holds the packet id.
+func (ua *UnsubscribeAcknowledgement) Decode(ver ProtocolVersion, buf []byte) error {
+	var err error
+	ua.PacketID, buf, err = decodeUint16(buf)
+	if err != nil {
+		return err
+	}
+	if ver != ProtoVer5 {
+		if len(buf) > 0 {
+			return RCMalformedPacket
+		}
+		return nil
+	}
+	buf, err = ua.Properties.Decode(buf)
+	if err != nil {
+		return err
+	}
+	ua.ReasonCodes = make([]RCode, 0, len(buf))
+	for len(buf) > 0 {
+		var code RCode
+		code, buf, err = decodeRCode(buf)
+		if err != nil {
+			return err
+		}
+		ua.ReasonCodes = append(ua.ReasonCodes, code)
+	}
+	return nil
+}
+
+func (ua *UnsubscribeAcknowledgement) Encode(ver ProtocolVersion, buf *bytes.Buffer) error {
+	var err error
+	_, err = buf.Write(encodeUint16(ua.PacketID))
+	if err != nil {
+		return err
+	}
+	if ver != ProtoVer5 {
+		return nil
+	}
+	err = ua.Properties.Encode(buf)
+	if err != nil {
+		return err
+	}
+	for _, code := range ua.ReasonCodes {
+		err = buf.WriteByte(byte(code))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ua *UnsubscribeAcknowledgement) Type() CPType {
+	return UNSUBACK
+}
+
+func (ua *UnsubscribeAcknowledgement) Validate() RCode {
+	if ua.PacketID == 0 { // [MQTT-2.2.1-3]
+		return RCMalformedPacket
+	}
+	for _, code := range ua.ReasonCodes {
+		if !unsubackReasonCodes[code] {
+			return RCProtocolError
+		}
+	}
+	return RCSuccess
+}
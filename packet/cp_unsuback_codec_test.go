@@ -0,0 +1,102 @@
+package packet
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type UnsubAckCodecTestcase struct {
+	Name      string
+	EncodeVer ProtocolVersion
+
+	// data
+	Request      *UnsubscribeAcknowledgement
+	RequestBytes []byte
+}
+
+func TestUnsubAck(t *testing.T) {
+	encodeRunner := func(t *testing.T, tc UnsubAckCodecTestcase) bool {
+		rcode := tc.Request.Validate()
+		if rcode != RCSuccess {
+			t.Errorf("expected \n%v\nbut got \n%v", RCSuccess, rcode)
+			return false
+		}
+		buf := bytes.NewBuffer(nil)
+		err := tc.Request.Encode(tc.EncodeVer, buf)
+		if err != nil {
+			t.Errorf("expected \n%v\nbut got \n%v", tc.RequestBytes, err)
+			return false
+		}
+		if !bytes.Equal(buf.Bytes(), tc.RequestBytes) {
+			t.Errorf("\nexpected \n%v\ngot \n%v", tc.RequestBytes, buf.Bytes())
+			return false
+		}
+		return true
+	}
+
+	decodeRunner := func(t *testing.T, tc UnsubAckCodecTestcase) bool {
+		request := &UnsubscribeAcknowledgement{}
+		err := request.Decode(tc.EncodeVer, tc.RequestBytes)
+		if err != nil {
+			t.Errorf("decode expected \n%v\nbut got \n%v", JSON(tc.Request), err)
+			return false
+		}
+		if !reflect.DeepEqual(tc.Request, request) {
+			t.Errorf("decode expected \n%v\nbut got \n%v", JSON(tc.Request), JSON(request))
+			return false
+		}
+		return true
+	}
+
+	for _, tc := range UnsubAckCodecTestcases {
+		t.Run(tc.Name+" decode", func(t *testing.T) {
+			if !decodeRunner(t, tc) {
+				t.FailNow()
+			}
+		})
+		t.Run(tc.Name+" encode", func(t *testing.T) {
+			if !encodeRunner(t, tc) {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+var UnsubAckCodecTestcases = []UnsubAckCodecTestcase{
+	{
+		Name:      "basic",
+		EncodeVer: ProtoVer5,
+		Request: &UnsubscribeAcknowledgement{
+			PacketID: 10,
+			Properties: BaseProperties{
+				ReasonString: "reason",
+				UserProperty: []*UserProperty{
+					{Key: "user1", Val: "value1"},
+				},
+			},
+			ReasonCodes: []RCode{RCSuccess, RCNoSubscriptionExisted, RCNotAuthorized},
+		},
+		RequestBytes: []byte{
+			0, 10, // Packet ID
+			25,                                 // Properties Length
+			byte(IDReasonString),               // Reason String ID
+			0, 6, 'r', 'e', 'a', 's', 'o', 'n', // Reason String Value
+			byte(IDUserProperty),                                              // User Property ID
+			0, 5, 'u', 's', 'e', 'r', '1', 0, 6, 'v', 'a', 'l', 'u', 'e', '1', // User Property 1
+			byte(RCSuccess),               // Reason Code 1
+			byte(RCNoSubscriptionExisted), // Reason Code 2
+			byte(RCNotAuthorized),         // Reason Code 3
+		},
+	},
+	{
+		Name:      "v3.1.1",
+		EncodeVer: ProtoVer311,
+		Request: &UnsubscribeAcknowledgement{
+			PacketID: 10,
+		},
+		RequestBytes: []byte{
+			0, 10, // Packet ID
+		},
+	},
+}
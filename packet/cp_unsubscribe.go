@@ -0,0 +1,152 @@
+package packet
+
+import (
+	"bytes"
+	"fmt"
+)
+
+type UnsubscribeRequest struct {
+	PacketID     uint16
+	Properties   UnsubscribeRequestProperties
+	TopicFilters []string
+}
+
+type UnsubscribeRequestProperties struct {
+	UserProperty []*UserProperty
+}
+
+func (urp *UnsubscribeRequestProperties) Decode(buf []byte) ([]byte, error) {
+	var length uint32
+	var err error
+	length, buf, err = decodeLength(buf)
+	if err != nil {
+		return buf, err
+	}
+	if length == 0 {
+		return buf, nil
+	}
+	shouldRemain := len(buf) - int(length)
+	for len(buf) > shouldRemain {
+		var id Identifier
+		id, buf, err = decodeIdentifier(buf)
+		if err != nil {
+			return buf, err
+		}
+		switch id {
+		case IDUserProperty:
+			var key string
+			key, buf, err = decodeString(buf)
+			if err == nil {
+				var value string
+				value, buf, err = decodeString(buf)
+				if err == nil {
+					if urp.UserProperty == nil {
+						urp.UserProperty = make([]*UserProperty, 0, 1)
+					}
+					urp.UserProperty = append(urp.UserProperty, &UserProperty{Key: key, Val: value})
+				}
+			}
+		default:
+			err = fmt.Errorf("unknown identifier: %d", id)
+		}
+		if err != nil {
+			return buf, err
+		}
+	}
+	return buf, nil
+}
+
+func (urp *UnsubscribeRequestProperties) Encode(buf *bytes.Buffer) error {
+	var err error
+	tmpBuf := bytes.NewBuffer(nil)
+	for _, userProperty := range urp.UserProperty {
+		err = tmpBuf.WriteByte(byte(IDUserProperty))
+		if err != nil {
+			return err
+		}
+		_, err = tmpBuf.Write(encodeString(userProperty.Key))
+		if err != nil {
+			return err
+		}
+		_, err = tmpBuf.Write(encodeString(userProperty.Val))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = buf.Write(encodeLength(uint32(tmpBuf.Len())))
+	if err != nil {
+		return err
+	}
+	if tmpBuf.Len() > 0 {
+		_, err = buf.Write(tmpBuf.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ur *UnsubscribeRequest) Decode(ver ProtocolVersion, buf []byte) error {
+	var err error
+	ur.PacketID, buf, err = decodeUint16(buf)
+	if err != nil {
+		return err
+	}
+	if ver == ProtoVer5 {
+		buf, err = ur.Properties.Decode(buf)
+		if err != nil {
+			return err
+		}
+	}
+	ur.TopicFilters = make([]string, 0)
+	for len(buf) > 0 {
+		var filter string
+		filter, buf, err = decodeString(buf)
+		if err != nil {
+			return err
+		}
+		ur.TopicFilters = append(ur.TopicFilters, filter)
+	}
+	return nil
+}
+
+func (ur *UnsubscribeRequest) Encode(ver ProtocolVersion, buf *bytes.Buffer) error {
+	var err error
+	_, err = buf.Write(encodeUint16(ur.PacketID))
+	if err != nil {
+		return err
+	}
+	if ver == ProtoVer5 {
+		err = ur.Properties.Encode(buf)
+		if err != nil {
+			return err
+		}
+	}
+	for _, filter := range ur.TopicFilters {
+		_, err = buf.Write(encodeString(filter))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ur *UnsubscribeRequest) Type() CPType {
+	return UNSUBSCRIBE
+}
+
+func (ur *UnsubscribeRequest) Validate() RCode {
+	if ur.PacketID == 0 { // [MQTT-2.2.1-3]
+		return RCMalformedPacket
+	}
+	if len(ur.TopicFilters) == 0 { // [MQTT-3.10.3-2]
+		return RCProtocolError
+	}
+	for _, filter := range ur.TopicFilters {
+		if !ValidTopicFilter(filter) {
+			return RCTopicFilterInvalid
+		}
+	}
+	return RCSuccess
+}
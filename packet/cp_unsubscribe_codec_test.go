@@ -0,0 +1,100 @@
+package packet
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type UnsubscribeCodecTestcase struct {
+	Name      string
+	EncodeVer ProtocolVersion
+
+	// data
+	Request      *UnsubscribeRequest
+	RequestBytes []byte
+}
+
+func TestUnsubscribe(t *testing.T) {
+	encodeRunner := func(t *testing.T, tc UnsubscribeCodecTestcase) bool {
+		rcode := tc.Request.Validate()
+		if rcode != RCSuccess {
+			t.Errorf("expected \n%v\nbut got \n%v", RCSuccess, rcode)
+			return false
+		}
+		buf := bytes.NewBuffer(nil)
+		err := tc.Request.Encode(tc.EncodeVer, buf)
+		if err != nil {
+			t.Errorf("expected \n%v\nbut got \n%v", tc.RequestBytes, err)
+			return false
+		}
+		if !bytes.Equal(buf.Bytes(), tc.RequestBytes) {
+			t.Errorf("\nexpected \n%v\ngot \n%v", tc.RequestBytes, buf.Bytes())
+			return false
+		}
+		return true
+	}
+
+	decodeRunner := func(t *testing.T, tc UnsubscribeCodecTestcase) bool {
+		request := &UnsubscribeRequest{}
+		err := request.Decode(tc.EncodeVer, tc.RequestBytes)
+		if err != nil {
+			t.Errorf("decode expected \n%v\nbut got \n%v", JSON(tc.Request), err)
+			return false
+		}
+		if !reflect.DeepEqual(tc.Request, request) {
+			t.Errorf("decode expected \n%v\nbut got \n%v", JSON(tc.Request), JSON(request))
+			return false
+		}
+		return true
+	}
+
+	for _, tc := range UnsubscribeCodecTestcases {
+		t.Run(tc.Name+" decode", func(t *testing.T) {
+			if !decodeRunner(t, tc) {
+				t.FailNow()
+			}
+		})
+		t.Run(tc.Name+" encode", func(t *testing.T) {
+			if !encodeRunner(t, tc) {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+var UnsubscribeCodecTestcases = []UnsubscribeCodecTestcase{
+	{
+		Name:      "basic",
+		EncodeVer: ProtoVer5,
+		Request: &UnsubscribeRequest{
+			PacketID: 10,
+			Properties: UnsubscribeRequestProperties{
+				UserProperty: []*UserProperty{
+					{Key: "user1", Val: "value1"},
+				},
+			},
+			TopicFilters: []string{"topic1", "a/+/c"},
+		},
+		RequestBytes: []byte{
+			0, 10, // Packet ID
+			16,                                                                // properties length
+			byte(IDUserProperty),                                              // User Property ID
+			0, 5, 'u', 's', 'e', 'r', '1', 0, 6, 'v', 'a', 'l', 'u', 'e', '1', // User Property 1
+			0, 6, 't', 'o', 'p', 'i', 'c', '1', // Topic Filter 1
+			0, 5, 'a', '/', '+', '/', 'c', // Topic Filter 2
+		},
+	},
+	{
+		Name:      "v3.1.1",
+		EncodeVer: ProtoVer311,
+		Request: &UnsubscribeRequest{
+			PacketID:     10,
+			TopicFilters: []string{"a/b"},
+		},
+		RequestBytes: []byte{
+			0, 10, // Packet ID
+			0, 3, 'a', '/', 'b', // Topic Filter
+		},
+	},
+}
@@ -1,5 +1,10 @@
 package packet
 
+import (
+	"bytes"
+	"io"
+)
+
 // FixedHeader is a struct that represents the fixed header of a MQTT packet.
 type FixedHeader struct {
 	flags byte
@@ -7,6 +12,82 @@ type FixedHeader struct {
 	rlen  uint32 // Remaining Length
 }
 
+// NewFixedHeader creates a fixed header for the given control packet type.
+func NewFixedHeader(typ CPType, flags byte, rlen uint32) *FixedHeader {
+	return &FixedHeader{flags: flags & 0x0F, typ: typ, rlen: rlen}
+}
+
+// Encode writes the fixed header, including the remaining length, to buf.
+func (fh *FixedHeader) Encode(buf *bytes.Buffer) error {
+	if fh.rlen > MaxRemainingLength {
+		return RCPacketTooLarge
+	}
+	err := buf.WriteByte(byte(fh.typ)<<4 | fh.flags&0x0F)
+	if err != nil {
+		return err
+	}
+	_, err = buf.Write(encodeLength(fh.rlen))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Decode reads the fixed header from r.
+func (fh *FixedHeader) Decode(r io.ByteReader) error {
+	b, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	fh.typ = CPType(b >> 4)
+	fh.flags = b & 0x0F
+
+	var multiplier uint32 = 1
+	fh.rlen = 0
+	for i := 0; ; i++ {
+		if i >= 4 { // the remaining length is at most four bytes
+			return RCMalformedPacket
+		}
+		b, err = r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		fh.rlen += uint32(b&127) * multiplier
+		if b&128 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	return nil
+}
+
+// Validate checks the flags of the fixed header against the control packet type. [MQTT-2.1.3-1]
+func (fh *FixedHeader) Validate() RCode {
+	switch fh.typ {
+	case Reserved:
+		return RCMalformedPacket
+	case PUBLISH:
+		if QoS(fh.flags>>1&0x03) > QoS2 { // [MQTT-3.3.1-4]
+			return RCMalformedPacket
+		}
+	case PUBREL, SUBSCRIBE, UNSUBSCRIBE:
+		if fh.flags != 0x02 {
+			return RCMalformedPacket
+		}
+	default:
+		if fh.flags != 0 {
+			return RCMalformedPacket
+		}
+	}
+	if fh.rlen > MaxRemainingLength {
+		return RCMalformedPacket
+	}
+	return RCSuccess
+}
+
 // GetType returns the type of the control packet.
 func (fh *FixedHeader) GetType() CPType {
 	if fh == nil {
@@ -15,7 +96,15 @@ func (fh *FixedHeader) GetType() CPType {
 	return fh.typ
 }
 
-// GetRemainLength returns the remaining length of the control packet.
+// GetFlags returns the four flag bits of the control packet.
+func (fh *FixedHeader) GetFlags() byte {
+	if fh == nil {
+		return 0
+	}
+	return fh.flags
+}
+
+// GetRemainingLength returns the remaining length of the control packet.
 func (fh *FixedHeader) GetRemainingLength() uint32 {
 	if fh == nil {
 		return 0
@@ -2,9 +2,45 @@ package packet
 
 import "bytes"
 
+// Codec encodes and decodes the variable header and payload of a control packet for a protocol version.
 type Codec interface {
 	Encode(ProtocolVersion, *bytes.Buffer) error
-	Decode([]byte) error
+	Decode(ProtocolVersion, []byte) error
+}
+
+// Packet is a control packet that can be framed by a fixed header.
+type Packet interface {
+	Codec
+	Type() CPType
+	Validate() RCode
+}
+
+// cpType2Packet creates an empty packet for each control packet type that has a codec.
+var cpType2Packet = map[CPType]func() Packet{
+	CONNECT:     func() Packet { return &ConnectionRequest{} },
+	CONNACK:     func() Packet { return &ConnectAcknowledgement{} },
+	PUBLISH:     func() Packet { return &PublishMessage{} },
+	PUBACK:      func() Packet { return &PublishAcknowledgement{} },
+	PUBREC:      func() Packet { return &PublishReceived{} },
+	PUBREL:      func() Packet { return &PublishRelease{} },
+	PUBCOMP:     func() Packet { return &PublishComplete{} },
+	SUBSCRIBE:   func() Packet { return &SubscribeRequest{} },
+	SUBACK:      func() Packet { return &SubscribeAcknowledgement{} },
+	UNSUBSCRIBE: func() Packet { return &UnsubscribeRequest{} },
+	UNSUBACK:    func() Packet { return &UnsubscribeAcknowledgement{} },
+	PINGREQ:     func() Packet { return &PingRequest{} },
+	PINGRESP:    func() Packet { return &PingResponse{} },
+	DISCONNECT:  func() Packet { return &Disconnect{} },
+	AUTH:        func() Packet { return &Authentication{} },
+}
+
+// NewPacket creates an empty packet of the given control packet type.
+func NewPacket(typ CPType) (Packet, error) {
+	newFn, ok := cpType2Packet[typ]
+	if !ok {
+		return nil, RCProtocolError
+	}
+	return newFn(), nil
 }
 
 const MaxRemainingLength = 268435455
@@ -22,6 +58,14 @@ var protoVer2ProtocolName = map[ProtocolVersion]ProtocolName{
 	ProtoVer5:   FixedProtocolNameV5,
 }
 
+// Direction is the flow of a control packet.
+type Direction byte
+
+const (
+	ClientToServer Direction = 1 << iota // Sent by the client
+	ServerToClient                       // Sent by the server
+)
+
 // MQTT QoS 0,1,2
 type QoS byte
 
@@ -23,7 +23,6 @@ func (c RCode) Is(err error) bool {
 
 var rcode2reason = map[RCode]string{
 	RCSuccess:                             "Success",
-	RCNormalDisconnection:                 "Normal disconnection",
 	RCGrantedQoS1:                         "Granted QoS 1",
 	RCGrantedQoS2:                         "Granted QoS 2",
 	RCDisconnectWithWill:                  "Disconnect with Will Message",
@@ -77,9 +76,10 @@ func (r RCode) reason() string {
 
 const (
 	RCSuccess                             = RCode(0x00)
-	RCNormalDisconnection                 = RCode(0x01)
-	RCGrantedQoS1                         = RCode(0x02)
-	RCGrantedQoS2                         = RCode(0x03)
+	RCNormalDisconnection                 = RCode(0x00)
+	RCGrantedQoS0                         = RCode(0x00)
+	RCGrantedQoS1                         = RCode(0x01)
+	RCGrantedQoS2                         = RCode(0x02)
 	RCDisconnectWithWill                  = RCode(0x04)
 	RCNoMatchingSubscribers               = RCode(0x10)
 	RCNoSubscriptionExisted               = RCode(0x11)
@@ -0,0 +1,145 @@
+package packet
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// flagged is implemented by packets that carry fields in the fixed header flags.
+type flagged interface {
+	flags() byte
+	setFlags(byte)
+}
+
+// cpType2Flags holds the fixed flags of the control packets that reserve them. [MQTT-2.1.3-1]
+var cpType2Flags = map[CPType]byte{
+	PUBREL:      0x02,
+	SUBSCRIBE:   0x02,
+	UNSUBSCRIBE: 0x02,
+}
+
+// packetFlags returns the fixed header flags of a packet.
+func packetFlags(p Packet) byte {
+	if f, ok := p.(flagged); ok {
+		return f.flags()
+	}
+	return cpType2Flags[p.Type()]
+}
+
+// Reader reads control packets from a byte stream.
+type Reader struct {
+	r             *bufio.Reader
+	ver           ProtocolVersion
+	maxPacketSize uint32
+}
+
+// NewReader creates a packet reader. A maxPacketSize of 0 only limits packets to MaxRemainingLength.
+func NewReader(r io.Reader, maxPacketSize uint32) *Reader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Reader{r: br, ver: ProtoVer5, maxPacketSize: maxPacketSize}
+}
+
+// SetProtocolVersion sets the protocol version negotiated by CONNECT. Packets are decoded as v5 until it is set.
+func (r *Reader) SetProtocolVersion(ver ProtocolVersion) {
+	r.ver = ver
+}
+
+// SetMaxPacketSize changes the maximum size of a packet, including its fixed header.
+func (r *Reader) SetMaxPacketSize(size uint32) {
+	r.maxPacketSize = size
+}
+
+// ReadPacket reads a full control packet and decodes it into its typed representation.
+func (r *Reader) ReadPacket() (*FixedHeader, Packet, error) {
+	fh := &FixedHeader{}
+	err := fh.Decode(r.r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rcode := fh.Validate(); rcode != RCSuccess {
+		return fh, nil, rcode
+	}
+	if r.maxPacketSize > 0 {
+		size := uint64(1+len(encodeLength(fh.rlen))) + uint64(fh.rlen)
+		if size > uint64(r.maxPacketSize) {
+			return fh, nil, RCPacketTooLarge
+		}
+	}
+
+	body := make([]byte, fh.rlen)
+	_, err = io.ReadFull(r.r, body)
+	if err != nil {
+		if err == io.EOF {
+			return fh, nil, io.ErrUnexpectedEOF
+		}
+		return fh, nil, err
+	}
+
+	p, err := NewPacket(fh.typ)
+	if err != nil {
+		return fh, nil, err
+	}
+	if f, ok := p.(flagged); ok {
+		f.setFlags(fh.flags)
+	}
+	err = p.Decode(r.ver, body)
+	if err != nil {
+		return fh, nil, err
+	}
+	return fh, p, nil
+}
+
+// PacketSize returns the size of p encoded for a protocol version, including its fixed header.
+func PacketSize(ver ProtocolVersion, p Packet) (uint32, error) {
+	var body bytes.Buffer
+	if err := p.Encode(ver, &body); err != nil {
+		return 0, err
+	}
+	if body.Len() > MaxRemainingLength {
+		return 0, RCPacketTooLarge
+	}
+	return uint32(1 + len(encodeLength(uint32(body.Len()))) + body.Len()), nil
+}
+
+// Writer writes control packets to a byte stream. It is not safe for concurrent use.
+type Writer struct {
+	w      io.Writer
+	header bytes.Buffer
+	body   bytes.Buffer
+}
+
+// NewWriter creates a packet writer.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WritePacket encodes p with its fixed header and writes it in a single call.
+func (w *Writer) WritePacket(ver ProtocolVersion, p Packet) error {
+	w.header.Reset()
+	w.body.Reset()
+	err := p.Encode(ver, &w.body)
+	if err != nil {
+		return err
+	}
+	if w.body.Len() > MaxRemainingLength {
+		return RCPacketTooLarge
+	}
+	fh := NewFixedHeader(p.Type(), packetFlags(p), uint32(w.body.Len()))
+	err = fh.Encode(&w.header)
+	if err != nil {
+		return err
+	}
+	_, err = w.header.Write(w.body.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(w.header.Bytes())
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package packet
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type StreamTestcase struct {
+	Name      string
+	EncodeVer ProtocolVersion
+
+	// data
+	Packet      Packet
+	PacketBytes []byte
+}
+
+func TestStream(t *testing.T) {
+	writeRunner := func(t *testing.T, tc StreamTestcase) bool {
+		buf := bytes.NewBuffer(nil)
+		err := NewWriter(buf).WritePacket(tc.EncodeVer, tc.Packet)
+		if err != nil {
+			t.Errorf("expected \n%v\nbut got \n%v", tc.PacketBytes, err)
+			return false
+		}
+		if !bytes.Equal(buf.Bytes(), tc.PacketBytes) {
+			t.Errorf("\nexpected \n%v\ngot \n%v", tc.PacketBytes, buf.Bytes())
+			return false
+		}
+		return true
+	}
+
+	readRunner := func(t *testing.T, tc StreamTestcase) bool {
+		fh, p, err := NewReader(bytes.NewReader(tc.PacketBytes), 0).ReadPacket()
+		if err != nil {
+			t.Errorf("read expected \n%v\nbut got \n%v", JSON(tc.Packet), err)
+			return false
+		}
+		if fh.GetType() != tc.Packet.Type() {
+			t.Errorf("read expected type %v but got %v", tc.Packet.Type(), fh.GetType())
+			return false
+		}
+		if !reflect.DeepEqual(tc.Packet, p) {
+			t.Errorf("read expected \n%v\nbut got \n%v", JSON(tc.Packet), JSON(p))
+			return false
+		}
+		return true
+	}
+
+	for _, tc := range streamTestcases {
+		t.Run(tc.Name+" read", func(t *testing.T) {
+			if !readRunner(t, tc) {
+				t.FailNow()
+			}
+		})
+		t.Run(tc.Name+" write", func(t *testing.T) {
+			if !writeRunner(t, tc) {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+var streamTestcases = []StreamTestcase{
+	{
+		Name:      "connect v3.1.1",
+		EncodeVer: ProtoVer311,
+		Packet: &ConnectionRequest{
+			ProtocolName:    FixedProtocolNameV311,
+			ProtocolVersion: ProtoVer311,
+			ClientID:        "id1",
+			Keepalive:       10,
+		},
+		PacketBytes: []byte{
+			byte(CONNECT) << 4,       // Fixed Header
+			15,                       // Remaining Length
+			0, 4, 'M', 'Q', 'T', 'T', // Protocol Name
+			4,     // Protocol Version
+			0,     // Packet Flags
+			0, 10, // Keepalive
+			0, 3, 'i', 'd', '1', // Client ID
+		},
+	},
+	{
+		Name:      "puback",
+		EncodeVer: ProtoVer5,
+		Packet: &PublishAcknowledgement{
+			PacketID:   7,
+			ReasonCode: RCNoMatchingSubscribers,
+		},
+		PacketBytes: []byte{
+			byte(PUBACK) << 4, // Fixed Header
+			3,                 // Remaining Length
+			0, 7,              // Packet ID
+			byte(RCNoMatchingSubscribers), // Reason Code, without properties
+		},
+	},
+	{
+		Name:      "pubrel",
+		EncodeVer: ProtoVer5,
+		Packet: &PublishRelease{
+			PacketID: 7,
+		},
+		PacketBytes: []byte{
+			byte(PUBREL)<<4 | 0x02, // Fixed Header
+			2,                      // Remaining Length
+			0, 7,                   // Packet ID, a Success reason code is omitted
+		},
+	},
+	{
+		Name:      "publish qos1 retain",
+		EncodeVer: ProtoVer5,
+		Packet: &PublishMessage{
+			QoSLevel:  QoS1,
+			Retain:    true,
+			TopicName: "a/b",
+			PacketID:  3,
+			Payload:   []byte("hi"),
+		},
+		PacketBytes: []byte{
+			byte(PUBLISH)<<4 | 0x03, // Fixed Header
+			10,                      // Remaining Length
+			0, 3, 'a', '/', 'b',     // Topic Name
+			0, 3, // Packet ID
+			0,        // Properties Length
+			'h', 'i', // Payload
+		},
+	},
+	{
+		Name:      "pingreq",
+		EncodeVer: ProtoVer311,
+		Packet:    &PingRequest{},
+		PacketBytes: []byte{
+			byte(PINGREQ) << 4, // Fixed Header
+			0,                  // Remaining Length
+		},
+	},
+	{
+		Name:      "pingresp",
+		EncodeVer: ProtoVer5,
+		Packet:    &PingResponse{},
+		PacketBytes: []byte{
+			byte(PINGRESP) << 4, // Fixed Header
+			0,                   // Remaining Length
+		},
+	},
+}
+
+func TestStreamReadErrors(t *testing.T) {
+	caseList := []struct {
+		name          string
+		maxPacketSize uint32
+		data          []byte
+		expectErr     error
+	}{
+		{
+			name:      "empty stream",
+			data:      []byte{},
+			expectErr: io.EOF,
+		},
+		{
+			name:      "truncated remaining length",
+			data:      []byte{byte(PINGREQ) << 4, 0x80},
+			expectErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:      "remaining length longer than four bytes",
+			data:      []byte{byte(PUBACK) << 4, 0xFF, 0xFF, 0xFF, 0xFF, 0x01},
+			expectErr: RCMalformedPacket,
+		},
+		{
+			name:      "reserved flags",
+			data:      []byte{byte(PUBACK)<<4 | 0x01, 2, 0, 1},
+			expectErr: RCMalformedPacket,
+		},
+		{
+			name:      "reserved packet type",
+			data:      []byte{byte(Reserved) << 4, 0},
+			expectErr: RCMalformedPacket,
+		},
+		{
+			name:          "packet too large",
+			maxPacketSize: 5,
+			data:          []byte{byte(PUBACK) << 4, 4, 0, 1, 0, 0},
+			expectErr:     RCPacketTooLarge,
+		},
+		{
+			name:      "pingreq with body",
+			data:      []byte{byte(PINGREQ) << 4, 1, 0},
+			expectErr: RCMalformedPacket,
+		},
+		{
+			name:      "truncated body",
+			data:      []byte{byte(PUBACK) << 4, 4, 0, 1},
+			expectErr: io.ErrUnexpectedEOF,
+		},
+	}
+	for _, c := range caseList {
+		t.Run(c.name, func(t *testing.T) {
+			_, _, err := NewReader(bytes.NewReader(c.data), c.maxPacketSize).ReadPacket()
+			if !errors.Is(err, c.expectErr) {
+				t.Errorf("expected %v but got %v", c.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestPacketSize(t *testing.T) {
+	for _, tc := range streamTestcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			size, err := PacketSize(tc.EncodeVer, tc.Packet)
+			if err != nil || size != uint32(len(tc.PacketBytes)) {
+				t.Errorf("expected %d but got %d (%v)", len(tc.PacketBytes), size, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package packet
+
+import "strings"
+
+const (
+	TopicSeparator      = "/"
+	SingleLevelWildcard = "+"
+	MultiLevelWildcard  = "#"
+	SharedPrefix        = "$share"
+)
+
+// ValidTopicName checks that a topic name is not empty and contains no wildcard characters. [MQTT-4.7.3-1] [MQTT-3.3.2-2]
+func ValidTopicName(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	return !strings.ContainsAny(name, SingleLevelWildcard+MultiLevelWildcard)
+}
+
+// ValidTopicFilter checks the wildcard syntax of a topic filter, including shared subscriptions.
+func ValidTopicFilter(filter string) bool {
+	if IsSharedSubscription(filter) {
+		_, shared, ok := ParseSharedSubscription(filter)
+		return ok && validFilterLevels(shared)
+	}
+	return validFilterLevels(filter)
+}
+
+// validFilterLevels checks that '+' fills a whole level and '#' fills the last level. [MQTT-4.7.1-1] [MQTT-4.7.1-2]
+func validFilterLevels(filter string) bool {
+	if len(filter) == 0 { // [MQTT-4.7.3-1]
+		return false
+	}
+	levels := strings.Split(filter, TopicSeparator)
+	for i, level := range levels {
+		switch {
+		case level == MultiLevelWildcard:
+			if i != len(levels)-1 {
+				return false
+			}
+		case level == SingleLevelWildcard:
+		case strings.ContainsAny(level, SingleLevelWildcard+MultiLevelWildcard):
+			return false
+		}
+	}
+	return true
+}
+
+// HasWildcard reports whether a topic filter contains a wildcard.
+func HasWildcard(filter string) bool {
+	return strings.ContainsAny(filter, SingleLevelWildcard+MultiLevelWildcard)
+}
+
+// IsSharedSubscription reports whether a topic filter names a shared subscription.
+func IsSharedSubscription(filter string) bool {
+	return strings.HasPrefix(filter, SharedPrefix+TopicSeparator)
+}
+
+// ParseSharedSubscription splits '$share/{ShareName}/{filter}' into the share name and the topic filter. [MQTT-4.8.2-1] [MQTT-4.8.2-2]
+func ParseSharedSubscription(filter string) (string, string, bool) {
+	if !IsSharedSubscription(filter) {
+		return "", "", false
+	}
+	rest := filter[len(SharedPrefix)+len(TopicSeparator):]
+	idx := strings.Index(rest, TopicSeparator)
+	if idx <= 0 {
+		return "", "", false
+	}
+	group, filter := rest[:idx], rest[idx+len(TopicSeparator):]
+	if strings.ContainsAny(group, SingleLevelWildcard+MultiLevelWildcard) || len(filter) == 0 {
+		return "", "", false
+	}
+	return group, filter, true
+}
+
+// MatchTopic reports whether a topic name matches a topic filter.
+// Topic names starting with '$' are not matched by filters starting with a wildcard. [MQTT-4.7.2-1]
+func MatchTopic(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, SingleLevelWildcard) || strings.HasPrefix(filter, MultiLevelWildcard)) {
+		return false
+	}
+	filterLevels := strings.Split(filter, TopicSeparator)
+	topicLevels := strings.Split(topic, TopicSeparator)
+	for i, level := range filterLevels {
+		if level == MultiLevelWildcard { // '#' also matches the parent level [MQTT-4.7.1-2]
+			return true
+		}
+		if i == len(topicLevels) || level != SingleLevelWildcard && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
@@ -0,0 +1,77 @@
+package packet
+
+import "testing"
+
+func TestValidTopic(t *testing.T) {
+	caseList := []struct {
+		topic        string
+		expectName   bool
+		expectFilter bool
+	}{
+		{topic: "", expectName: false, expectFilter: false},
+		{topic: "a/b/c", expectName: true, expectFilter: true},
+		{topic: "/", expectName: true, expectFilter: true},
+		{topic: "$SYS/broker", expectName: true, expectFilter: true},
+		{topic: "#", expectName: false, expectFilter: true},
+		{topic: "+", expectName: false, expectFilter: true},
+		{topic: "a/+/c", expectName: false, expectFilter: true},
+		{topic: "a/#", expectName: false, expectFilter: true},
+		{topic: "+/+/#", expectName: false, expectFilter: true},
+		{topic: "a/#/c", expectName: false, expectFilter: false},
+		{topic: "a#", expectName: false, expectFilter: false},
+		{topic: "a/b+", expectName: false, expectFilter: false},
+		{topic: "$share/group/a/+", expectName: false, expectFilter: true},
+		{topic: "$share/group/#", expectName: false, expectFilter: true},
+		{topic: "$share/group", expectName: true, expectFilter: false},
+		{topic: "$share//a", expectName: true, expectFilter: false},
+		{topic: "$share/g+/a", expectName: false, expectFilter: false},
+		{topic: "$share/group/a/#/b", expectName: false, expectFilter: false},
+	}
+	for _, c := range caseList {
+		t.Run(c.topic, func(t *testing.T) {
+			if got := ValidTopicName(c.topic); got != c.expectName {
+				t.Errorf("ValidTopicName expected %v but got %v", c.expectName, got)
+			}
+			if got := ValidTopicFilter(c.topic); got != c.expectFilter {
+				t.Errorf("ValidTopicFilter expected %v but got %v", c.expectFilter, got)
+			}
+		})
+	}
+}
+
+func TestParseSharedSubscription(t *testing.T) {
+	group, filter, ok := ParseSharedSubscription("$share/consumer1/sport/tennis/+")
+	if !ok || group != "consumer1" || filter != "sport/tennis/+" {
+		t.Errorf("expected (consumer1, sport/tennis/+, true) but got (%s, %s, %v)", group, filter, ok)
+	}
+	_, _, ok = ParseSharedSubscription("sport/tennis/+")
+	if ok {
+		t.Errorf("expected a non-shared filter to be rejected")
+	}
+}
+
+func TestMatchTopic(t *testing.T) {
+	caseList := []struct {
+		filter string
+		topic  string
+		expect bool
+	}{
+		{filter: "a/b", topic: "a/b", expect: true},
+		{filter: "a/b", topic: "a/c", expect: false},
+		{filter: "a/+", topic: "a/b", expect: true},
+		{filter: "a/+", topic: "a/b/c", expect: false},
+		{filter: "a/+", topic: "a", expect: false},
+		{filter: "a/#", topic: "a", expect: true},
+		{filter: "a/#", topic: "a/b/c", expect: true},
+		{filter: "#", topic: "a/b", expect: true},
+		{filter: "+/+", topic: "/b", expect: true},
+		{filter: "#", topic: "$SYS/uptime", expect: false},
+		{filter: "+/uptime", topic: "$SYS/uptime", expect: false},
+		{filter: "$SYS/#", topic: "$SYS/uptime", expect: true},
+	}
+	for _, c := range caseList {
+		if got := MatchTopic(c.filter, c.topic); got != c.expect {
+			t.Errorf("MatchTopic(%q, %q) expected %v but got %v", c.filter, c.topic, c.expect, got)
+		}
+	}
+}
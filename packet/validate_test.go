@@ -0,0 +1,183 @@
+package packet
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	caseList := []struct {
+		name   string
+		packet Packet
+		expect RCode
+	}{
+		{
+			name:   "publish qos0",
+			packet: &PublishMessage{TopicName: "a/b"},
+			expect: RCSuccess,
+		},
+		{
+			name:   "publish wildcard topic",
+			packet: &PublishMessage{TopicName: "a/+"},
+			expect: RCTopicNameInvalid,
+		},
+		{
+			name:   "publish empty topic without alias",
+			packet: &PublishMessage{},
+			expect: RCProtocolError,
+		},
+		{
+			name:   "publish empty topic with alias",
+			packet: &PublishMessage{Properties: PublishMessageProperties{TopicAlias: 1}},
+			expect: RCSuccess,
+		},
+		{
+			name:   "publish qos3",
+			packet: &PublishMessage{TopicName: "a", QoSLevel: 3, PacketID: 1},
+			expect: RCMalformedPacket,
+		},
+		{
+			name:   "publish qos1 without packet id",
+			packet: &PublishMessage{TopicName: "a", QoSLevel: QoS1},
+			expect: RCMalformedPacket,
+		},
+		{
+			name:   "publish qos0 with dup",
+			packet: &PublishMessage{TopicName: "a", DUP: true},
+			expect: RCMalformedPacket,
+		},
+		{
+			name: "publish invalid utf-8 payload",
+			packet: &PublishMessage{
+				TopicName:  "a",
+				Properties: PublishMessageProperties{PayloadFormatIndicator: PFI_UTF8},
+				Payload:    []byte{0xff, 0xfe},
+			},
+			expect: RCPayloadFormatInvalid,
+		},
+		{
+			name: "subscribe",
+			packet: &SubscribeRequest{PacketID: 1, Payload: []*SubscribePayload{
+				{TopicFilter: "a/+/c", QoS: QoS2},
+				{TopicFilter: "$share/g/a/#", QoS: QoS1},
+			}},
+			expect: RCSuccess,
+		},
+		{
+			name:   "subscribe without packet id",
+			packet: &SubscribeRequest{Payload: []*SubscribePayload{{TopicFilter: "a"}}},
+			expect: RCMalformedPacket,
+		},
+		{
+			name:   "subscribe without topic filters",
+			packet: &SubscribeRequest{PacketID: 1},
+			expect: RCProtocolError,
+		},
+		{
+			name:   "subscribe invalid filter",
+			packet: &SubscribeRequest{PacketID: 1, Payload: []*SubscribePayload{{TopicFilter: "a/#/b"}}},
+			expect: RCTopicFilterInvalid,
+		},
+		{
+			name:   "subscribe qos3",
+			packet: &SubscribeRequest{PacketID: 1, Payload: []*SubscribePayload{{TopicFilter: "a", QoS: 3}}},
+			expect: RCMalformedPacket,
+		},
+		{
+			name:   "subscribe shared with no local",
+			packet: &SubscribeRequest{PacketID: 1, Payload: []*SubscribePayload{{TopicFilter: "$share/g/a", NoLocal: true}}},
+			expect: RCProtocolError,
+		},
+		{
+			name:   "subscribe invalid retain handling",
+			packet: &SubscribeRequest{PacketID: 1, Payload: []*SubscribePayload{{TopicFilter: "a", RetainHandling: 3}}},
+			expect: RCProtocolError,
+		},
+		{
+			name:   "suback",
+			packet: &SubscribeAcknowledgement{PacketID: 1, ReasonCodes: []RCode{RCGrantedQoS0, RCGrantedQoS2, RCNotAuthorized}},
+			expect: RCSuccess,
+		},
+		{
+			name:   "suback illegal reason code",
+			packet: &SubscribeAcknowledgement{PacketID: 1, ReasonCodes: []RCode{RCNoSubscriptionExisted}},
+			expect: RCProtocolError,
+		},
+		{
+			name:   "puback no matching subscribers",
+			packet: &PublishAcknowledgement{PacketID: 1, ReasonCode: RCNoMatchingSubscribers},
+			expect: RCSuccess,
+		},
+		{
+			name:   "puback without packet id",
+			packet: &PublishAcknowledgement{},
+			expect: RCMalformedPacket,
+		},
+		{
+			name:   "pubrec illegal reason code",
+			packet: &PublishReceived{PacketID: 1, ReasonCode: RCPacketIDNotFound},
+			expect: RCProtocolError,
+		},
+		{
+			name:   "pubrel packet id not found",
+			packet: &PublishRelease{PacketID: 1, ReasonCode: RCPacketIDNotFound},
+			expect: RCSuccess,
+		},
+		{
+			name:   "pubcomp illegal reason code",
+			packet: &PublishComplete{PacketID: 1, ReasonCode: RCNotAuthorized},
+			expect: RCProtocolError,
+		},
+		{
+			name:   "unsubscribe invalid filter",
+			packet: &UnsubscribeRequest{PacketID: 1, TopicFilters: []string{"a+"}},
+			expect: RCTopicFilterInvalid,
+		},
+		{
+			name:   "connect v3.1 client id of 23 characters",
+			packet: &ConnectionRequest{ProtocolName: FixedProtocolNameV31, ProtocolVersion: ProtoVer31, ClientID: strings.Repeat("c", 23)},
+			expect: RCSuccess,
+		},
+		{
+			name:   "connect v3.1 client id too long",
+			packet: &ConnectionRequest{ProtocolName: FixedProtocolNameV31, ProtocolVersion: ProtoVer31, ClientID: strings.Repeat("c", 24)},
+			expect: RClientIDNotValid,
+		},
+		{
+			name:   "connect v3.1 empty client id",
+			packet: &ConnectionRequest{ProtocolName: FixedProtocolNameV31, ProtocolVersion: ProtoVer31, CleanStart: NewFlagV(true)},
+			expect: RClientIDNotValid,
+		},
+		{
+			name:   "connect protocol name of another version",
+			packet: &ConnectionRequest{ProtocolName: FixedProtocolNameV31, ProtocolVersion: ProtoVer311, ClientID: "c"},
+			expect: RCUnsupportedProtocol,
+		},
+		{
+			name:   "connect v3.1.1 password without username",
+			packet: &ConnectionRequest{ProtocolName: FixedProtocolNameV311, ProtocolVersion: ProtoVer311, ClientID: "c", Password: NewPassword([]byte("p"))},
+			expect: RCMalformedPacket,
+		},
+	}
+	for _, c := range caseList {
+		t.Run(c.name, func(t *testing.T) {
+			if rcode := c.packet.Validate(); rcode != c.expect {
+				t.Errorf("expected %v but got %v", c.expect, rcode)
+			}
+		})
+	}
+}
+
+func TestValidateTopicAlias(t *testing.T) {
+	pm := &PublishMessage{TopicName: "a", Properties: PublishMessageProperties{TopicAlias: 10}}
+	if rcode := pm.ValidateTopicAlias(10); rcode != RCSuccess {
+		t.Errorf("expected %v but got %v", RCSuccess, rcode)
+	}
+	if rcode := pm.ValidateTopicAlias(9); rcode != RCTopicAliasInvalid {
+		t.Errorf("expected %v but got %v", RCTopicAliasInvalid, rcode)
+	}
+	props := &PublishMessageProperties{}
+	if _, err := props.Decode([]byte{3, byte(IDTopicAlias), 0, 0}); err != RCTopicAliasInvalid {
+		t.Errorf("expected %v but got %v", RCTopicAliasInvalid, err)
+	}
+}
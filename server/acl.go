@@ -0,0 +1,169 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+// Access is the kind of access a client requests to a topic.
+type Access byte
+
+const (
+	AccessRead      Access = 1 << iota                // subscribe to a topic filter
+	AccessWrite                                       // publish to a topic name
+	AccessReadWrite Access = AccessRead | AccessWrite // both
+)
+
+// Authorizer decides which topics a client may publish to and subscribe to.
+type Authorizer interface {
+	// Authorize reports whether a client may publish to a topic name (AccessWrite)
+	// or subscribe to a topic filter (AccessRead).
+	Authorize(info *ClientInfo, access Access, topic string) bool
+}
+
+// NewAllowAllAuthorizer creates an Authorizer that lets every client publish and subscribe to every topic.
+func NewAllowAllAuthorizer() Authorizer {
+	return allowAll{}
+}
+
+func (allowAll) Authorize(*ClientInfo, Access, string) bool {
+	return true
+}
+
+// NewACLFileAuthorizer creates an Authorizer from an ACL file. Each line of the file is one of
+//
+//	user <username>                    rules below apply to the client with this username
+//	topic [read|write|readwrite] <filter>
+//	pattern [read|write|readwrite] <filter>
+//
+// Topic rules before the first user line apply to every client. Pattern rules apply to every client,
+// with %c replaced by its client id and %u by its username. The access defaults to readwrite.
+// Empty lines and lines starting with '#' are ignored. A client is denied anything no rule grants.
+func NewACLFileAuthorizer(path string) (Authorizer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	acl := &aclFile{users: map[string][]aclRule{}}
+	var user *string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch {
+		case fields[0] == "user" && len(fields) == 2:
+			user = &fields[1]
+			continue
+		case fields[0] != "topic" && fields[0] != "pattern", len(fields) < 2, len(fields) > 3:
+			return nil, fmt.Errorf("%s:%d: invalid rule %q", path, n, line)
+		}
+		rule := aclRule{access: AccessReadWrite, filter: fields[len(fields)-1]}
+		if len(fields) == 3 {
+			access, ok := map[string]Access{"read": AccessRead, "write": AccessWrite, "readwrite": AccessReadWrite}[fields[1]]
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: invalid access %q", path, n, fields[1])
+			}
+			rule.access = access
+		}
+		if !packet.ValidTopicFilter(strings.NewReplacer("%c", "c", "%u", "u").Replace(rule.filter)) {
+			return nil, fmt.Errorf("%s:%d: invalid topic filter %q", path, n, rule.filter)
+		}
+		switch {
+		case fields[0] == "pattern":
+			acl.patterns = append(acl.patterns, rule)
+		case user == nil:
+			acl.global = append(acl.global, rule)
+		default:
+			acl.users[*user] = append(acl.users[*user], rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
+
+type aclRule struct {
+	access Access
+	filter string
+}
+
+type aclFile struct {
+	global   []aclRule
+	users    map[string][]aclRule
+	patterns []aclRule
+}
+
+func (acl *aclFile) Authorize(info *ClientInfo, access Access, topic string) bool {
+	for _, rule := range acl.global {
+		if rule.allows(access, rule.filter, topic) {
+			return true
+		}
+	}
+	for _, rule := range acl.users[info.Username] {
+		if rule.allows(access, rule.filter, topic) {
+			return true
+		}
+	}
+	// a client id or username with wildcards or separators could widen the pattern
+	substitute := func(s string) bool {
+		return !strings.ContainsAny(s, packet.TopicSeparator+packet.SingleLevelWildcard+packet.MultiLevelWildcard)
+	}
+	for _, rule := range acl.patterns {
+		if strings.Contains(rule.filter, "%c") && !substitute(info.ClientID) ||
+			strings.Contains(rule.filter, "%u") && (info.Username == "" || !substitute(info.Username)) {
+			continue
+		}
+		filter := strings.NewReplacer("%c", info.ClientID, "%u", info.Username).Replace(rule.filter)
+		if rule.allows(access, filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether the rule grants access to a topic name, or to every topic a topic filter matches.
+func (rule aclRule) allows(access Access, filter, topic string) bool {
+	if rule.access&access != access {
+		return false
+	}
+	if access&AccessRead != 0 {
+		return coversFilter(filter, topic)
+	}
+	return packet.MatchTopic(filter, topic)
+}
+
+// coversFilter reports whether every topic name matched by sub is also matched by filter.
+func coversFilter(filter, sub string) bool {
+	if strings.HasPrefix(sub, "$") && (strings.HasPrefix(filter, packet.SingleLevelWildcard) ||
+		strings.HasPrefix(filter, packet.MultiLevelWildcard)) { // [MQTT-4.7.2-1]
+		return false
+	}
+	filterLevels := strings.Split(filter, packet.TopicSeparator)
+	subLevels := strings.Split(sub, packet.TopicSeparator)
+	for i, level := range filterLevels {
+		if level == packet.MultiLevelWildcard {
+			return true
+		}
+		if i == len(subLevels) {
+			return false
+		}
+		switch subLevel := subLevels[i]; {
+		case subLevel == packet.MultiLevelWildcard:
+			return false
+		case level == packet.SingleLevelWildcard:
+		case subLevel == packet.SingleLevelWildcard || level != subLevel:
+			return false
+		}
+	}
+	return len(filterLevels) == len(subLevels)
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+func TestCoversFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		sub    string
+		expect bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/+", "a/b", true},
+		{"a/+", "a/+", true},
+		{"a/b", "a/+", false},
+		{"a/+", "a/#", false},
+		{"a/#", "a", true},
+		{"a/#", "a/+/c", true},
+		{"a/+", "a/b/c", false},
+		{"#", "$SYS/#", false},
+		{"$SYS/#", "$SYS/+", true},
+	}
+	for _, tt := range tests {
+		if got := coversFilter(tt.filter, tt.sub); got != tt.expect {
+			t.Errorf("coversFilter(%q, %q) expected %v but got %v", tt.filter, tt.sub, tt.expect, got)
+		}
+	}
+}
+
+func TestACLFileAuthorizer(t *testing.T) {
+	acl, err := NewACLFileAuthorizer(writeLinesFile(t, "acl",
+		"# public",
+		"topic read public/#",
+		"pattern devices/%c/#",
+		"pattern write users/%u/out",
+		"user alice",
+		"topic readwrite alice/+",
+	))
+	if err != nil {
+		t.Fatalf("failed to load ACL file: %v", err)
+	}
+	alice := &ClientInfo{ClientID: "phone", Username: "alice"}
+	anon := &ClientInfo{ClientID: "sensor"}
+	wild := &ClientInfo{ClientID: "+", Username: "#"}
+	tests := []struct {
+		name   string
+		info   *ClientInfo
+		access Access
+		topic  string
+		expect bool
+	}{
+		{"global read", anon, AccessRead, "public/news/+", true},
+		{"global write", anon, AccessWrite, "public/news", false},
+		{"user rule", alice, AccessWrite, "alice/notes", true},
+		{"user rule for other user", anon, AccessWrite, "alice/notes", false},
+		{"user rule wider filter", alice, AccessRead, "alice/#", false},
+		{"client id pattern", anon, AccessRead, "devices/sensor/#", true},
+		{"other client id", anon, AccessWrite, "devices/phone/temp", false},
+		{"username pattern", alice, AccessWrite, "users/alice/out", true},
+		{"username pattern read", alice, AccessRead, "users/alice/out", false},
+		{"username pattern anonymous", anon, AccessWrite, "users//out", false},
+		{"wildcard client id", wild, AccessRead, "devices/x/y", false},
+		{"wildcard username", wild, AccessWrite, "users/a/out", false},
+	}
+	for _, tt := range tests {
+		if got := acl.Authorize(tt.info, tt.access, tt.topic); got != tt.expect {
+			t.Errorf("%s: expected %v but got %v", tt.name, tt.expect, got)
+		}
+	}
+
+	for _, line := range []string{"topic", "topic delete a", "topic a/#/b", "deny a"} {
+		if _, err := NewACLFileAuthorizer(writeLinesFile(t, "acl", line)); err == nil {
+			t.Errorf("expected %q to be rejected", line)
+		}
+	}
+}
+
+func TestServerAuthorization(t *testing.T) {
+	acl, err := NewACLFileAuthorizer(writeLinesFile(t, "acl", "topic read in/#", "topic write out/#"))
+	if err != nil {
+		t.Fatalf("failed to load ACL file: %v", err)
+	}
+	s := startTestServer(t, WithAuthorizer(acl))
+	tc := dialTestConn(t, s, packet.ProtoVer5)
+	tc.connect(&packet.ConnectionRequest{ClientID: "c"})
+
+	codes := tc.subscribe(
+		&packet.SubscribePayload{TopicFilter: "in/+"},
+		&packet.SubscribePayload{TopicFilter: "out/+"},
+	)
+	if codes[0] != packet.RCGrantedQoS0 || codes[1] != packet.RCNotAuthorized {
+		t.Errorf("unexpected SUBACK reason codes %v", codes)
+	}
+
+	tc.write(&packet.PublishMessage{QoSLevel: packet.QoS1, PacketID: 1, TopicName: "in/x"})
+	if ack, ok := tc.read().(*packet.PublishAcknowledgement); !ok || ack.ReasonCode != packet.RCNotAuthorized {
+		t.Errorf("expected PUBACK with %v", packet.RCNotAuthorized)
+	}
+	tc.write(&packet.PublishMessage{QoSLevel: packet.QoS2, PacketID: 2, TopicName: "in/x"})
+	if rec, ok := tc.read().(*packet.PublishReceived); !ok || rec.ReasonCode != packet.RCNotAuthorized {
+		t.Errorf("expected PUBREC with %v", packet.RCNotAuthorized)
+	}
+	tc.write(&packet.PublishMessage{QoSLevel: packet.QoS1, PacketID: 3, TopicName: "out/x"})
+	if ack, ok := tc.read().(*packet.PublishAcknowledgement); !ok || ack.ReasonCode != packet.RCNoMatchingSubscribers {
+		t.Errorf("expected PUBACK with %v", packet.RCNoMatchingSubscribers)
+	}
+
+	will := dialTestConn(t, s, packet.ProtoVer5)
+	connack := will.connect(&packet.ConnectionRequest{ClientID: "w", Will: packet.NewFlagV(packet.ConnectWill{Topic: "in/lwt", Payload: []byte("x")})})
+	if connack.ConnectReasonCode != packet.RCNotAuthorized {
+		t.Errorf("expected a will to an unauthorized topic to be rejected with %v", packet.RCNotAuthorized)
+	}
+}
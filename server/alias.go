@@ -0,0 +1,86 @@
+package server
+
+import (
+	"container/list"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+// inboundAliases holds the topic aliases a client set on its connection.
+type inboundAliases map[uint16]string
+
+// resolve sets the topic name of a PUBLISH that uses a topic alias. A topic name with an alias replaces
+// the mapping of the alias, an empty topic name uses it.
+func (a inboundAliases) resolve(pm *packet.PublishMessage, maximum uint16) packet.RCode {
+	alias := pm.Properties.TopicAlias
+	if alias == 0 {
+		return packet.RCSuccess
+	}
+	if rcode := pm.ValidateTopicAlias(maximum); rcode != packet.RCSuccess {
+		return rcode
+	}
+	if pm.TopicName != "" {
+		a[alias] = pm.TopicName
+		return packet.RCSuccess
+	}
+	topic, ok := a[alias]
+	if !ok { // an alias must be set before it is used
+		return packet.RCProtocolError
+	}
+	pm.TopicName = topic
+	return packet.RCSuccess
+}
+
+// outboundAliases assigns topic aliases to the topics sent to a client, bounded by the Topic Alias Maximum
+// of the client. When all aliases are in use, the least recently used one is assigned to the new topic.
+type outboundAliases struct {
+	maximum uint16
+	lru     *list.List               // of *aliasEntry, most recently used first
+	topics  map[string]*list.Element // by topic name
+}
+
+type aliasEntry struct {
+	topic string
+	alias uint16
+}
+
+func newOutboundAliases(maximum uint16) *outboundAliases {
+	return &outboundAliases{maximum: maximum, lru: list.New(), topics: map[string]*list.Element{}}
+}
+
+// assign returns the alias of a topic and whether the topic name must be sent to set it.
+// It returns 0 if the client accepts no aliases.
+func (a *outboundAliases) assign(topic string) (uint16, bool) {
+	if a.maximum == 0 {
+		return 0, true
+	}
+	if e, ok := a.topics[topic]; ok {
+		a.lru.MoveToFront(e)
+		return e.Value.(*aliasEntry).alias, false
+	}
+	var entry *aliasEntry
+	if a.lru.Len() < int(a.maximum) {
+		entry = &aliasEntry{alias: uint16(a.lru.Len()) + 1}
+	} else {
+		e := a.lru.Back()
+		entry = a.lru.Remove(e).(*aliasEntry)
+		delete(a.topics, entry.topic)
+	}
+	entry.topic = topic
+	a.topics[topic] = a.lru.PushFront(entry)
+	return entry.alias, true
+}
+
+// apply returns the PUBLISH to write in place of pm, using a topic alias if possible.
+func (a *outboundAliases) apply(pm *packet.PublishMessage) *packet.PublishMessage {
+	alias, establish := a.assign(pm.TopicName)
+	if alias == 0 {
+		return pm
+	}
+	out := *pm
+	out.Properties.TopicAlias = alias
+	if !establish {
+		out.TopicName = ""
+	}
+	return &out
+}
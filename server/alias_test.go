@@ -0,0 +1,89 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+func TestInboundAliases(t *testing.T) {
+	aliases := inboundAliases{}
+	publish := func(topic string, alias uint16) *packet.PublishMessage {
+		return &packet.PublishMessage{TopicName: topic, Properties: packet.PublishMessageProperties{TopicAlias: alias}}
+	}
+
+	if rcode := aliases.resolve(publish("", 1), 10); rcode != packet.RCProtocolError {
+		t.Errorf("expected %v for an unknown alias but got %v", packet.RCProtocolError, rcode)
+	}
+	if rcode := aliases.resolve(publish("a/b", 11), 10); rcode != packet.RCTopicAliasInvalid {
+		t.Errorf("expected %v for an alias above the maximum but got %v", packet.RCTopicAliasInvalid, rcode)
+	}
+	if rcode := aliases.resolve(publish("a/b", 1), 10); rcode != packet.RCSuccess {
+		t.Fatalf("expected %v but got %v", packet.RCSuccess, rcode)
+	}
+	pm := publish("", 1)
+	if rcode := aliases.resolve(pm, 10); rcode != packet.RCSuccess || pm.TopicName != "a/b" {
+		t.Errorf("expected topic a/b but got %q (%v)", pm.TopicName, rcode)
+	}
+	aliases.resolve(publish("c", 1), 10)
+	pm = publish("", 1)
+	if aliases.resolve(pm, 10); pm.TopicName != "c" {
+		t.Errorf("expected the alias to be replaced by topic c but got %q", pm.TopicName)
+	}
+}
+
+func TestOutboundAliases(t *testing.T) {
+	aliases := newOutboundAliases(2)
+	tests := []struct {
+		topic     string
+		alias     uint16
+		establish bool
+	}{
+		{"a", 1, true},
+		{"b", 2, true},
+		{"a", 1, false},
+		{"c", 2, true}, // b is the least recently used
+		{"b", 1, true},
+		{"c", 2, false},
+	}
+	for i, tt := range tests {
+		alias, establish := aliases.assign(tt.topic)
+		if alias != tt.alias || establish != tt.establish {
+			t.Errorf("%d: expected (%d, %v) for %q but got (%d, %v)", i, tt.alias, tt.establish, tt.topic, alias, establish)
+		}
+	}
+
+	if alias, establish := newOutboundAliases(0).assign("a"); alias != 0 || !establish {
+		t.Errorf("expected no alias when the client accepts none")
+	}
+}
+
+func TestServerTopicAlias(t *testing.T) {
+	s := startTestServer(t, WithTopicAliasMaximum(5))
+	sub := dialTestConn(t, s, packet.ProtoVer5)
+	sub.connect(&packet.ConnectionRequest{ClientID: "sub", Properties: &packet.ConnectProperties{TopicAliasMaximum: 3}})
+	sub.subscribe(&packet.SubscribePayload{TopicFilter: "alias/#"})
+	pub := dialTestConn(t, s, packet.ProtoVer5)
+	connack := pub.connect(&packet.ConnectionRequest{ClientID: "pub"})
+	if connack.Properties.TopicAliasMaximum != 5 {
+		t.Fatalf("expected topic alias maximum 5 but got %d", connack.Properties.TopicAliasMaximum)
+	}
+
+	pub.write(&packet.PublishMessage{TopicName: "alias/a", Properties: packet.PublishMessageProperties{TopicAlias: 1}, Payload: []byte("1")})
+	pub.write(&packet.PublishMessage{Properties: packet.PublishMessageProperties{TopicAlias: 1}, Payload: []byte("2")})
+
+	pm := sub.readPublish()
+	if pm.TopicName != "alias/a" || pm.Properties.TopicAlias != 1 {
+		t.Errorf("expected alias/a to set alias 1 but got %v", packet.JSON(pm))
+	}
+	pm = sub.readPublish()
+	if pm.TopicName != "" || pm.Properties.TopicAlias != 1 || string(pm.Payload) != "2" {
+		t.Errorf("expected the second message to use alias 1 only but got %v", packet.JSON(pm))
+	}
+
+	pub.write(&packet.PublishMessage{TopicName: "alias/b", Properties: packet.PublishMessageProperties{TopicAlias: 6}})
+	if d, ok := pub.read().(*packet.Disconnect); !ok || d.ReasonCode != packet.RCTopicAliasInvalid {
+		t.Errorf("expected DISCONNECT with %v", packet.RCTopicAliasInvalid)
+	}
+	pub.expectClosed()
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+// ClientInfo describes the client of a connection to the authentication and authorization hooks.
+type ClientInfo struct {
+	ClientID        string
+	Username        string
+	RemoteAddr      net.Addr
+	ProtocolVersion packet.ProtocolVersion
+	// Certificate is the verified client certificate of a TLS connection, or nil. Its subject common name
+	// and subject alternative names identify the client to hooks authenticating devices by certificate.
+	Certificate *x509.Certificate
+	// Mountpoint is the prefix of the topic names and filters of the client, set from its listener.
+	// The authenticator may change it.
+	Mountpoint string
+}
+
+// Authenticator decides whether a client may connect. [MQTT-3.1.4-2]
+type Authenticator interface {
+	// Authenticate checks the CONNECT of a client. It returns RCSuccess to accept the client,
+	// RCContinueAuthentication with the data of an AUTH packet to start enhanced authentication,
+	// or the CONNACK reason code to reject it with, such as RCBadUsernameOrPassword, RCNotAuthorized or RCBanned.
+	// It may change the Username and Mountpoint of info, as seen by the authorizer.
+	Authenticate(info *ClientInfo, cr *packet.ConnectionRequest) (packet.RCode, []byte)
+}
+
+// EnhancedAuthenticator is an Authenticator that supports enhanced authentication.
+type EnhancedAuthenticator interface {
+	Authenticator
+	// SupportsMethod reports whether the authenticator implements an authentication method.
+	SupportsMethod(method string) bool
+	// AuthenticateContinue checks an AUTH packet sent by the client during the exchange.
+	// It returns the same results as Authenticate. A connected client starts re-authentication
+	// with an AUTH packet with RCReAuthenticate, and a failure reason code closes its connection.
+	AuthenticateContinue(info *ClientInfo, auth *packet.Authentication) (packet.RCode, []byte)
+}
+
+// NewAllowAllAuthenticator creates an Authenticator that accepts every client.
+func NewAllowAllAuthenticator() Authenticator {
+	return allowAll{}
+}
+
+type allowAll struct{}
+
+func (allowAll) Authenticate(*ClientInfo, *packet.ConnectionRequest) (packet.RCode, []byte) {
+	return packet.RCSuccess, nil
+}
+
+// NewPasswordFileAuthenticator creates an Authenticator that accepts the clients whose username and password
+// are listed in a file. Each line of the file is "username:salt:hash", where salt is hex encoded and hash is
+// the hex encoded SHA-256 of the salt followed by the password, as created by HashPassword.
+// Empty lines and lines starting with '#' are ignored.
+func NewPasswordFileAuthenticator(path string) (Authenticator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := map[string]passwordHash{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected username:salt:hash", path, n)
+		}
+		salt, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid salt: %w", path, n, err)
+		}
+		hash, err := hex.DecodeString(fields[2])
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("%s:%d: invalid hash", path, n)
+		}
+		users[fields[0]] = passwordHash{salt: salt, hash: hash}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &passwordFile{users: users}, nil
+}
+
+// HashPassword returns the "salt:hash" part of a password file line for a password, with a random salt.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(salt) + ":" + hex.EncodeToString(saltedHash(salt, []byte(password))), nil
+}
+
+func saltedHash(salt, password []byte) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write(password)
+	return h.Sum(nil)
+}
+
+type passwordHash struct {
+	salt []byte
+	hash []byte
+}
+
+type passwordFile struct {
+	users map[string]passwordHash
+}
+
+func (pf *passwordFile) Authenticate(_ *ClientInfo, cr *packet.ConnectionRequest) (packet.RCode, []byte) {
+	if !cr.Username.Flag() || !cr.Password.Flag() {
+		return packet.RCBadUsernameOrPassword, nil
+	}
+	user, ok := pf.users[string(cr.Username.Value())]
+	if !ok {
+		return packet.RCBadUsernameOrPassword, nil
+	}
+	if subtle.ConstantTimeCompare(saltedHash(user.salt, cr.Password.Value()), user.hash) != 1 {
+		return packet.RCBadUsernameOrPassword, nil
+	}
+	return packet.RCSuccess, nil
+}
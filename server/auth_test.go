@@ -0,0 +1,221 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+// writeLinesFile writes the lines of a password or ACL file to a temporary file named name.
+func writeLinesFile(t *testing.T, name string, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	content := ""
+	for _, line := range lines {
+		content += line + "\n"
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestPasswordFileAuthenticator(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	auth, err := NewPasswordFileAuthenticator(writeLinesFile(t, "passwd", "# users", "", "alice:"+hash))
+	if err != nil {
+		t.Fatalf("failed to load password file: %v", err)
+	}
+	tests := []struct {
+		name     string
+		username *string
+		password *string
+		expect   packet.RCode
+	}{
+		{"valid", ptr("alice"), ptr("secret"), packet.RCSuccess},
+		{"wrong password", ptr("alice"), ptr("guess"), packet.RCBadUsernameOrPassword},
+		{"unknown user", ptr("bob"), ptr("secret"), packet.RCBadUsernameOrPassword},
+		{"no password", ptr("alice"), nil, packet.RCBadUsernameOrPassword},
+		{"anonymous", nil, nil, packet.RCBadUsernameOrPassword},
+	}
+	for _, tt := range tests {
+		cr := &packet.ConnectionRequest{}
+		if tt.username != nil {
+			cr.Username = packet.NewFlagV([]byte(*tt.username))
+		}
+		if tt.password != nil {
+			cr.Password = packet.NewSPassword(*tt.password)
+		}
+		if rcode, _ := auth.Authenticate(&ClientInfo{}, cr); rcode != tt.expect {
+			t.Errorf("%s: expected %v but got %v", tt.name, tt.expect, rcode)
+		}
+	}
+
+	for _, line := range []string{"alice", "alice:zz:00", "alice:00:00"} {
+		if _, err := NewPasswordFileAuthenticator(writeLinesFile(t, "passwd", line)); err == nil {
+			t.Errorf("expected %q to be rejected", line)
+		}
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
+
+// challengeAuthenticator accepts clients that answer its challenge with the challenge reversed.
+type challengeAuthenticator struct{}
+
+func (challengeAuthenticator) Authenticate(_ *ClientInfo, cr *packet.ConnectionRequest) (packet.RCode, []byte) {
+	if cr.Properties == nil || cr.Properties.AuthenticationMethod == "" {
+		return packet.RCNotAuthorized, nil
+	}
+	return packet.RCContinueAuthentication, []byte("abc")
+}
+
+func (challengeAuthenticator) SupportsMethod(method string) bool {
+	return method == "REVERSE"
+}
+
+func (challengeAuthenticator) AuthenticateContinue(_ *ClientInfo, auth *packet.Authentication) (packet.RCode, []byte) {
+	if auth.ReasonCode == packet.RCReAuthenticate {
+		return packet.RCContinueAuthentication, []byte("abc")
+	}
+	if !bytes.Equal(auth.Data(), []byte("cba")) {
+		return packet.RCNotAuthorized, nil
+	}
+	return packet.RCSuccess, []byte("ok")
+}
+
+func TestServerAuthentication(t *testing.T) {
+	hash, _ := HashPassword("secret")
+	passwords, err := NewPasswordFileAuthenticator(writeLinesFile(t, "passwd", "alice:"+hash))
+	if err != nil {
+		t.Fatalf("failed to load password file: %v", err)
+	}
+	s := startTestServer(t, WithAuthenticator(passwords))
+
+	tc := dialTestConn(t, s, packet.ProtoVer5)
+	connack := tc.connect(&packet.ConnectionRequest{ClientID: "c", Username: packet.NewFlagV([]byte("alice")), Password: packet.NewSPassword("nope")})
+	if connack.ConnectReasonCode != packet.RCBadUsernameOrPassword {
+		t.Errorf("expected %v but got %v", packet.RCBadUsernameOrPassword, connack.ConnectReasonCode)
+	}
+	tc.expectClosed()
+
+	tc = dialTestConn(t, s, packet.ProtoVer5)
+	connack = tc.connect(&packet.ConnectionRequest{ClientID: "c", Username: packet.NewFlagV([]byte("alice")), Password: packet.NewSPassword("secret")})
+	if connack.ConnectReasonCode != packet.RCSuccess {
+		t.Errorf("expected %v but got %v", packet.RCSuccess, connack.ConnectReasonCode)
+	}
+
+	tc = dialTestConn(t, s, packet.ProtoVer5)
+	connack = tc.connect(&packet.ConnectionRequest{ClientID: "d", Properties: &packet.ConnectProperties{AuthenticationMethod: "REVERSE"}})
+	if connack.ConnectReasonCode != packet.RCBadAuthenticationMethod {
+		t.Errorf("expected %v but got %v", packet.RCBadAuthenticationMethod, connack.ConnectReasonCode)
+	}
+}
+
+func TestServerEnhancedAuthentication(t *testing.T) {
+	s := startTestServer(t, WithAuthenticator(challengeAuthenticator{}))
+	connect := func(tc *testConn, answer string) *packet.ConnectAcknowledgement {
+		tc.write(&packet.ConnectionRequest{
+			ProtocolName:    packet.FixedProtocolNameV5,
+			ProtocolVersion: packet.ProtoVer5,
+			ClientID:        "e",
+			Properties:      &packet.ConnectProperties{AuthenticationMethod: "REVERSE"},
+		})
+		challenge, ok := tc.read().(*packet.Authentication)
+		if !ok || challenge.ReasonCode != packet.RCContinueAuthentication || string(challenge.Data()) != "abc" {
+			t.Fatalf("expected an AUTH challenge")
+		}
+		tc.write(&packet.Authentication{
+			ReasonCode: packet.RCContinueAuthentication,
+			Properties: &packet.AuthenticationProperties{AuthenticationMethod: "REVERSE", AuthenticationData: []byte(answer)},
+		})
+		connack, ok := tc.read().(*packet.ConnectAcknowledgement)
+		if !ok {
+			t.Fatalf("expected CONNACK")
+		}
+		return connack
+	}
+
+	tc := dialTestConn(t, s, packet.ProtoVer5)
+	connack := connect(tc, "cba")
+	if connack.ConnectReasonCode != packet.RCSuccess || connack.Properties.AuthenticationMethod != "REVERSE" ||
+		string(connack.Properties.AuthenticationData) != "ok" {
+		t.Errorf("unexpected CONNACK %v", packet.JSON(connack))
+	}
+	if connack := connect(dialTestConn(t, s, packet.ProtoVer5), "abc"); connack.ConnectReasonCode != packet.RCNotAuthorized {
+		t.Errorf("expected %v but got %v", packet.RCNotAuthorized, connack.ConnectReasonCode)
+	}
+
+	auth := func(rcode packet.RCode, method, data string) *packet.Authentication {
+		return &packet.Authentication{
+			ReasonCode: rcode,
+			Properties: &packet.AuthenticationProperties{AuthenticationMethod: method, AuthenticationData: []byte(data)},
+		}
+	}
+	expectDisconnect := func(tc *testConn, rcode packet.RCode) {
+		t.Helper()
+		if d, ok := tc.read().(*packet.Disconnect); !ok || d.ReasonCode != rcode {
+			t.Errorf("expected DISCONNECT with %v", rcode)
+		}
+		tc.expectClosed()
+	}
+
+	t.Run("re-authentication", func(t *testing.T) {
+		tc.write(auth(packet.RCReAuthenticate, "REVERSE", ""))
+		if challenge, ok := tc.read().(*packet.Authentication); !ok || challenge.ReasonCode != packet.RCContinueAuthentication || string(challenge.Data()) != "abc" {
+			t.Fatalf("expected an AUTH challenge")
+		}
+		// other packets are exchanged during re-authentication
+		tc.write(&packet.PingRequest{})
+		if _, ok := tc.read().(*packet.PingResponse); !ok {
+			t.Fatalf("expected PINGRESP")
+		}
+		tc.write(auth(packet.RCContinueAuthentication, "REVERSE", "cba"))
+		if done, ok := tc.read().(*packet.Authentication); !ok || done.ReasonCode != packet.RCSuccess || string(done.Data()) != "ok" {
+			t.Fatalf("expected a successful AUTH")
+		}
+
+		tc.write(auth(packet.RCReAuthenticate, "REVERSE", ""))
+		tc.read()
+		tc.write(auth(packet.RCContinueAuthentication, "REVERSE", "abc"))
+		expectDisconnect(tc, packet.RCNotAuthorized)
+	})
+
+	t.Run("another method", func(t *testing.T) {
+		tc := dialTestConn(t, s, packet.ProtoVer5)
+		connect(tc, "cba")
+		tc.write(auth(packet.RCReAuthenticate, "OTHER", ""))
+		expectDisconnect(tc, packet.RCProtocolError)
+	})
+
+	t.Run("continue without re-authenticate", func(t *testing.T) {
+		tc := dialTestConn(t, s, packet.ProtoVer5)
+		connect(tc, "cba")
+		tc.write(auth(packet.RCContinueAuthentication, "REVERSE", "cba"))
+		expectDisconnect(tc, packet.RCProtocolError)
+	})
+
+	t.Run("without enhanced authentication", func(t *testing.T) {
+		s := startTestServer(t)
+		tc := dialTestConn(t, s, packet.ProtoVer5)
+		tc.connect(&packet.ConnectionRequest{ClientID: "plain"})
+		tc.write(auth(packet.RCReAuthenticate, "REVERSE", ""))
+		expectDisconnect(tc, packet.RCProtocolError)
+
+		// AUTH is a reserved packet type before v5
+		tc = dialTestConn(t, s, packet.ProtoVer311)
+		tc.connect(&packet.ConnectionRequest{ClientID: "v311", CleanStart: packet.NewFlagV(true)})
+		if _, err := tc.conn.Write([]byte{0xF0, 0x00}); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		tc.expectClosed()
+	})
+}
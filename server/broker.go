@@ -1 +1,150 @@
 package server
+
+import (
+	"time"
+
+	"github.com/rwasayc/cactusmq/base"
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+// broker routes application messages to the subscriptions of clients.
+type broker struct {
+	subscriptions *base.TopicTree[subscriber, *packet.SubscribePayload]
+	shares        *shareBalancer
+}
+
+// subscriber identifies a subscription to a topic filter: a client, and the share name of a shared subscription.
+type subscriber struct {
+	clientID string
+	group    string
+}
+
+func newBroker(strategy ShareStrategy, online func(clientID string) bool) *broker {
+	return &broker{
+		subscriptions: base.NewTopicTree[subscriber, *packet.SubscribePayload](),
+		shares:        newShareBalancer(strategy, online),
+	}
+}
+
+// subscribe stores a subscription of a client. It returns whether the client already had a subscription to the filter.
+func (b *broker) subscribe(clientID string, sub *packet.SubscribePayload) bool {
+	filter, key := parseSubscription(clientID, sub.TopicFilter)
+	return b.subscriptions.Insert(filter, key, sub)
+}
+
+// unsubscribe removes a subscription of a client. It returns whether the subscription existed.
+func (b *broker) unsubscribe(clientID, filter string) bool {
+	filter, key := parseSubscription(clientID, filter)
+	return b.subscriptions.Remove(filter, key)
+}
+
+// parseSubscription returns the topic filter a subscription is indexed by and its subscriber.
+func parseSubscription(clientID, filter string) (string, subscriber) {
+	if group, shared, ok := packet.ParseSharedSubscription(filter); ok {
+		return shared, subscriber{clientID: clientID, group: group}
+	}
+	return filter, subscriber{clientID: clientID}
+}
+
+// delivery is a message for one client, merged from all its non-shared subscriptions matching the topic,
+// or from the shared subscription it was chosen for.
+type delivery struct {
+	clientID          string
+	qos               packet.QoS
+	retainAsPublished bool
+	subscriptionIDs   []int     // of the subscriptions the delivery comes from [MQTT-3.3.4-3]
+	share             *shareKey // the shared subscription of the delivery, nil for non-shared ones
+}
+
+// match returns the deliveries of a message published by a client.
+// A client with overlapping subscriptions receives the message once with the maximum QoS, and each
+// shared subscription delivers it to one of its members. [MQTT-3.3.4-2]
+func (b *broker) match(publisher string, topic string) []*delivery {
+	merged := map[string]*delivery{}
+	groups := map[shareKey][]*shareMember{}
+	b.subscriptions.Match(topic, func(filter string, key subscriber, sub *packet.SubscribePayload) bool {
+		if key.group != "" {
+			k := shareKey{group: key.group, filter: filter}
+			groups[k] = append(groups[k], &shareMember{clientID: key.clientID, sub: sub})
+			return true
+		}
+		if sub.NoLocal && key.clientID == publisher { // [MQTT-3.8.3-3]
+			return true
+		}
+		d, ok := merged[key.clientID]
+		if !ok {
+			d = &delivery{clientID: key.clientID}
+			merged[key.clientID] = d
+		}
+		if sub.QoS > d.qos {
+			d.qos = sub.QoS
+		}
+		d.retainAsPublished = d.retainAsPublished || sub.RetainAsPublished
+		if sub.SubscriptionID != 0 {
+			d.subscriptionIDs = append(d.subscriptionIDs, sub.SubscriptionID)
+		}
+		return true
+	})
+
+	deliveries := make([]*delivery, 0, len(merged)+len(groups))
+	for _, d := range merged {
+		deliveries = append(deliveries, d)
+	}
+	for k, members := range groups {
+		if d := b.shares.pick(k, members, publisher); d != nil {
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries
+}
+
+// rematch chooses another member of a shared subscription for a message its member excluded did not acknowledge.
+// It returns nil if the shared subscription has no other member.
+func (b *broker) rematch(k shareKey, publisher, excluded string) *delivery {
+	var members []*shareMember
+	b.subscriptions.Range(k.filter, func(key subscriber, sub *packet.SubscribePayload) bool {
+		if key.group == k.group && key.clientID != excluded {
+			members = append(members, &shareMember{clientID: key.clientID, sub: sub})
+		}
+		return true
+	})
+	return b.shares.pick(k, members, publisher)
+}
+
+// publish routes a message to the sessions subscribed to its topic and returns the number of receivers.
+// A retained message also replaces the retained message of its topic.
+func (s *Server) publish(publisher string, pm *packet.PublishMessage) int {
+	if pm.Retain && s.opts.retainAvailable {
+		s.retained.retain(pm)
+	}
+	src := &message{pm: pm, publisher: publisher, expiresAt: expiryTime(pm, time.Now())}
+	deliveries := s.broker.match(publisher, pm.TopicName)
+	for _, d := range deliveries {
+		s.forward(src, d)
+	}
+	return len(deliveries)
+}
+
+// forward hands a copy of a message over to the session of a delivery.
+func (s *Server) forward(src *message, d *delivery) {
+	sess, ok := s.sessions.Load(d.clientID)
+	if !ok {
+		return
+	}
+	sess.deliver(&message{pm: src.pm, publisher: src.publisher, expiresAt: src.expiresAt, delivery: d}, s.opts.maxQueued)
+}
+
+// outgoing creates the PUBLISH forwarded to a subscriber.
+func outgoing(pm *packet.PublishMessage, d *delivery) *packet.PublishMessage {
+	out := &packet.PublishMessage{
+		QoSLevel:   min(pm.QoSLevel, d.qos),          // [MQTT-3.8.4-8]
+		Retain:     pm.Retain && d.retainAsPublished, // [MQTT-3.3.1-12] [MQTT-3.3.1-13]
+		TopicName:  pm.TopicName,
+		Properties: pm.Properties,
+		Payload:    pm.Payload,
+	}
+	// topic aliases belong to a single connection
+	out.Properties.TopicAlias = 0
+	out.Properties.SubscriptionIdentifier = d.subscriptionIDs
+	return out
+}
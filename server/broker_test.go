@@ -0,0 +1,141 @@
+package server
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+// subscribe sends a SUBSCRIBE and returns the SUBACK reason codes.
+func (tc *testConn) subscribe(subs ...*packet.SubscribePayload) []packet.RCode {
+	tc.t.Helper()
+	tc.write(&packet.SubscribeRequest{PacketID: 1, Payload: subs})
+	suback, ok := tc.read().(*packet.SubscribeAcknowledgement)
+	if !ok {
+		tc.t.Fatalf("expected SUBACK")
+	}
+	return suback.ReasonCodes
+}
+
+func (tc *testConn) readPublish() *packet.PublishMessage {
+	tc.t.Helper()
+	pm, ok := tc.read().(*packet.PublishMessage)
+	if !ok {
+		tc.t.Fatalf("expected PUBLISH")
+	}
+	return pm
+}
+
+func TestBrokerRouting(t *testing.T) {
+	s := startTestServer(t)
+	sub := dialTestConn(t, s, packet.ProtoVer5)
+	sub.connect(&packet.ConnectionRequest{ClientID: "sub"})
+	pub := dialTestConn(t, s, packet.ProtoVer5)
+	pub.connect(&packet.ConnectionRequest{ClientID: "pub"})
+
+	codes := sub.subscribe(
+		&packet.SubscribePayload{TopicFilter: "sensor/+/temp", QoS: packet.QoS1},
+		&packet.SubscribePayload{TopicFilter: "sensor/#"},
+		&packet.SubscribePayload{TopicFilter: "sensor/#/bad"},
+	)
+	expect := []packet.RCode{packet.RCGrantedQoS1, packet.RCGrantedQoS0, packet.RCTopicFilterInvalid}
+	for i := range expect {
+		if codes[i] != expect[i] {
+			t.Fatalf("expected %v but got %v", expect, codes)
+		}
+	}
+
+	pub.write(&packet.PublishMessage{QoSLevel: packet.QoS1, PacketID: 7, TopicName: "sensor/1/temp", Payload: []byte("21")})
+	if ack, ok := pub.read().(*packet.PublishAcknowledgement); !ok || ack.PacketID != 7 || ack.ReasonCode != packet.RCSuccess {
+		t.Fatalf("expected PUBACK for packet 7")
+	}
+	pm := sub.readPublish()
+	if pm.TopicName != "sensor/1/temp" || string(pm.Payload) != "21" {
+		t.Errorf("unexpected message %v", packet.JSON(pm))
+	}
+
+	pub.write(&packet.PublishMessage{QoSLevel: packet.QoS1, PacketID: 8, TopicName: "other"})
+	if ack, ok := pub.read().(*packet.PublishAcknowledgement); !ok || ack.ReasonCode != packet.RCNoMatchingSubscribers {
+		t.Fatalf("expected PUBACK with %v", packet.RCNoMatchingSubscribers)
+	}
+
+	sub.write(&packet.UnsubscribeRequest{PacketID: 2, TopicFilters: []string{"sensor/#", "nothing"}})
+	unsuback, ok := sub.read().(*packet.UnsubscribeAcknowledgement)
+	if !ok || unsuback.ReasonCodes[0] != packet.RCSuccess || unsuback.ReasonCodes[1] != packet.RCNoSubscriptionExisted {
+		t.Fatalf("unexpected UNSUBACK")
+	}
+}
+
+func TestBrokerNoLocal(t *testing.T) {
+	s := startTestServer(t)
+	tc := dialTestConn(t, s, packet.ProtoVer5)
+	tc.connect(&packet.ConnectionRequest{ClientID: "self"})
+	tc.subscribe(&packet.SubscribePayload{TopicFilter: "chat", NoLocal: true})
+	tc.subscribe(&packet.SubscribePayload{TopicFilter: "echo"})
+
+	tc.write(&packet.PublishMessage{TopicName: "chat", Payload: []byte("dropped")})
+	tc.write(&packet.PublishMessage{TopicName: "echo", Payload: []byte("kept")})
+	if pm := tc.readPublish(); pm.TopicName != "echo" {
+		t.Errorf("expected only the echo message but got %v", pm.TopicName)
+	}
+}
+
+func TestBrokerMatch(t *testing.T) {
+	b := newBroker(ShareRoundRobin, func(string) bool { return true })
+	b.subscribe("c1", &packet.SubscribePayload{TopicFilter: "a/+", QoS: packet.QoS1})
+	b.subscribe("c1", &packet.SubscribePayload{TopicFilter: "a/#", QoS: packet.QoS2, RetainAsPublished: true})
+	b.subscribe("c2", &packet.SubscribePayload{TopicFilter: "a/b", NoLocal: true})
+
+	deliveries := b.match("c2", "a/b")
+	if len(deliveries) != 1 {
+		t.Fatalf("expected 1 delivery but got %d", len(deliveries))
+	}
+	d := deliveries[0]
+	if d.clientID != "c1" || d.qos != packet.QoS2 || !d.retainAsPublished {
+		t.Errorf("expected merged QoS 2 with retain as published but got %+v", d)
+	}
+	out := outgoing(&packet.PublishMessage{QoSLevel: packet.QoS1, Retain: true, TopicName: "a/b"}, d)
+	if out.QoSLevel != packet.QoS1 || !out.Retain {
+		t.Errorf("unexpected outgoing message %v", packet.JSON(out))
+	}
+}
+
+func TestBrokerSubscriptionIdentifiers(t *testing.T) {
+	s := startTestServer(t)
+	tc := dialTestConn(t, s, packet.ProtoVer5)
+	tc.connect(&packet.ConnectionRequest{ClientID: "ids"})
+	tc.subscribe(&packet.SubscribePayload{TopicFilter: "a/+", SubscriptionID: 1})
+	tc.subscribe(&packet.SubscribePayload{TopicFilter: "a/#", SubscriptionID: 300})
+	tc.subscribe(&packet.SubscribePayload{TopicFilter: "b"})
+
+	tc.write(&packet.PublishMessage{TopicName: "a/b"})
+	ids := tc.readPublish().Properties.SubscriptionIdentifier
+	sort.Ints(ids)
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 300 {
+		t.Errorf("expected subscription identifiers [1 300] but got %v", ids)
+	}
+	tc.write(&packet.PublishMessage{TopicName: "b"})
+	if ids := tc.readPublish().Properties.SubscriptionIdentifier; len(ids) != 0 {
+		t.Errorf("expected no subscription identifier but got %v", ids)
+	}
+
+	tc.write(&packet.PublishMessage{TopicName: "a/b", Properties: packet.PublishMessageProperties{SubscriptionIdentifier: []int{1}}})
+	if d, ok := tc.read().(*packet.Disconnect); !ok || d.ReasonCode != packet.RCProtocolError {
+		t.Errorf("expected DISCONNECT with %v for a PUBLISH with a subscription identifier", packet.RCProtocolError)
+	}
+}
+
+func TestBrokerSubscriptionIdentifiersUnavailable(t *testing.T) {
+	s := startTestServer(t, WithSubscriptionIdentifierAvailable(false))
+	tc := dialTestConn(t, s, packet.ProtoVer5)
+	connack := tc.connect(&packet.ConnectionRequest{ClientID: "ids"})
+	if available := connack.Properties.SubscriptionIdentifierAvailable; !available.Flag() || available.Value() != 0 {
+		t.Fatalf("expected subscription identifiers to be unavailable")
+	}
+	tc.write(&packet.SubscribeRequest{PacketID: 1, Payload: []*packet.SubscribePayload{{TopicFilter: "a", SubscriptionID: 1}}})
+	if d, ok := tc.read().(*packet.Disconnect); !ok || d.ReasonCode != packet.RCSubscriptionIdentifiersNotSupported {
+		t.Errorf("expected DISCONNECT with %v", packet.RCSubscriptionIdentifiersNotSupported)
+	}
+	tc.expectClosed()
+}
@@ -1 +1,616 @@
 package server
+
+import (
+	"errors"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+const (
+	outboundSize = 128             // packets queued for the write loop
+	flushTimeout = 5 * time.Second // time allowed to flush queued packets when closing
+)
+
+// client is a network connection and, after CONNECT, the MQTT client behind it.
+type client struct {
+	server   *Server
+	conn     net.Conn
+	endpoint *endpoint // the listener that accepted the connection
+	reader   *packet.Reader
+	writer   *packet.Writer
+
+	id        string
+	ver       packet.ProtocolVersion
+	connect   *packet.ConnectionRequest
+	info      *ClientInfo
+	will      *packet.PublishMessage // published when the connection closes without a normal DISCONNECT
+	keepalive *keepaliveMonitor
+	connected atomic.Bool
+	session   *Session
+
+	mountpoint       string // prefix of the topics of the client
+	reauthenticating bool   // an AUTH exchange started by the client is in progress, only used by the read loop
+
+	receiveMaximum    uint16 // QoS 1 and 2 messages the client accepts in flight
+	maximumPacketSize uint32 // size of the largest packet the client accepts, 0 if unlimited
+
+	aliases    inboundAliases   // topic aliases set by the client, only used by the read loop
+	outAliases *outboundAliases // topic aliases set by the server, only used by the write loop
+
+	outbound   chan packet.Packet
+	congested  atomic.Bool   // a session message did not fit in outbound, set by the session
+	closing    chan struct{} // closed when the connection starts closing
+	writerDone chan struct{} // closed when the write loop exits
+	done       chan struct{} // closed when the connection is fully closed
+	stopOnce   sync.Once
+	reason     packet.RCode // reason of a server initiated disconnect
+	notify     bool         // whether the reason is sent in a DISCONNECT
+}
+
+func newClient(s *Server, conn net.Conn, e *endpoint) *client {
+	return &client{
+		server:     s,
+		conn:       conn,
+		endpoint:   e,
+		reader:     packet.NewReader(conn, s.opts.maximumPacketSize),
+		writer:     packet.NewWriter(conn),
+		ver:        packet.ProtoVer5,
+		aliases:    inboundAliases{},
+		outbound:   make(chan packet.Packet, outboundSize),
+		closing:    make(chan struct{}),
+		writerDone: make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// serve runs the connection until it is closed.
+func (c *client) serve() {
+	go c.writeLoop()
+	err := c.handshake()
+	if err == nil {
+		err = c.readLoop()
+	}
+	c.stopWith(err)
+	<-c.writerDone
+	if c.keepalive != nil {
+		c.keepalive.Stop()
+	}
+	if c.connected.Load() {
+		shared := c.session.detach(c)
+		c.server.unregister(c)
+		c.server.redistribute(c.session, shared)
+	}
+	close(c.done)
+}
+
+// handshake reads the CONNECT packet and answers it with a CONNACK. [MQTT-3.1.0-1]
+func (c *client) handshake() error {
+	c.conn.SetReadDeadline(time.Now().Add(c.server.opts.connectTimeout))
+	_, p, err := c.reader.ReadPacket()
+	if err != nil {
+		return err
+	}
+	cr, ok := p.(*packet.ConnectionRequest)
+	if !ok {
+		return errNotConnected
+	}
+	c.connect = cr
+	c.ver = cr.ProtocolVersion
+	if !c.ver.IsValid() {
+		// an unknown version is refused with the CONNACK every version understands
+		c.ver = packet.ProtoVer311
+	}
+	c.reader.SetProtocolVersion(c.ver)
+	c.info = &ClientInfo{
+		ClientID:        cr.ClientID,
+		Username:        string(cr.Username.Value()),
+		RemoteAddr:      c.conn.RemoteAddr(),
+		ProtocolVersion: cr.ProtocolVersion,
+		Certificate:     verifiedCertificate(c.conn),
+		Mountpoint:      c.endpoint.config.Mountpoint,
+	}
+
+	connack := &packet.ConnectAcknowledgement{}
+	if c.ver == packet.ProtoVer5 {
+		connack.Properties = c.server.connackProperties()
+	}
+
+	rcode := c.server.checkConnect(cr)
+	if rcode == packet.RCSuccess && !c.endpoint.allows(cr.ProtocolVersion) {
+		rcode = packet.RCUnsupportedProtocol
+	}
+	if rcode == packet.RCSuccess {
+		rcode, err = c.authenticate(cr, connack)
+		if err != nil {
+			return err
+		}
+	}
+	if rcode == packet.RCSuccess && !validMountpoint(c.info.Mountpoint) {
+		rcode = packet.RCImplementationSpecific
+	}
+	if rcode != packet.RCSuccess {
+		// a CONNACK with a failure reason code is followed by closing the connection [MQTT-3.2.2-7]
+		connack.ConnectReasonCode = rcode
+		c.send(connack)
+		return errNotConnected
+	}
+
+	c.id = cr.ClientID
+	if c.id == "" { // [MQTT-3.1.3-6]
+		c.id = c.server.assignClientID()
+		if connack.Properties != nil {
+			connack.Properties.AssignedClientIdentifier = c.id
+		}
+	}
+	c.info.ClientID = c.id
+	c.mountpoint = c.info.Mountpoint
+	c.will = willMessage(cr)
+	if c.will != nil {
+		c.will.TopicName = c.mount(c.will.TopicName)
+	}
+	if c.will != nil && !c.server.opts.authorizer.Authorize(c.info, AccessWrite, c.will.TopicName) {
+		connack.ConnectReasonCode = packet.RCNotAuthorized
+		c.send(connack)
+		return errNotConnected
+	}
+	c.conn.SetReadDeadline(time.Time{})
+	c.receiveMaximum = math.MaxUint16
+	var aliasMaximum uint16
+	if props := cr.Properties; props != nil {
+		if props.ReceiveMaximum > 0 {
+			c.receiveMaximum = props.ReceiveMaximum
+		}
+		c.maximumPacketSize = props.MaximumPacketSize
+		aliasMaximum = props.TopicAliasMaximum
+	}
+	c.outAliases = newOutboundAliases(aliasMaximum)
+
+	keepalive, override := negotiateKeepalive(cr.Keepalive, c.server.opts.serverKeepAlive, c.ver)
+	if override {
+		connack.Properties.ServerKeepAlive = keepalive
+	}
+	c.keepalive = newKeepaliveMonitor(keepaliveTimeout(keepalive), c.disconnect)
+
+	connack.SessionPresent = c.server.register(c)
+	c.connected.Store(true)
+	c.send(connack)
+	c.session.attach(c)
+	return nil
+}
+
+// authenticate checks a CONNECT with the authenticator of the server, running the AUTH exchange of
+// enhanced authentication until it succeeds or fails.
+func (c *client) authenticate(cr *packet.ConnectionRequest, connack *packet.ConnectAcknowledgement) (packet.RCode, error) {
+	method := c.authMethod()
+	enhanced, ok := c.server.opts.authenticator.(EnhancedAuthenticator)
+	if method != "" && (!ok || !enhanced.SupportsMethod(method)) { // [MQTT-4.12.0-1]
+		return packet.RCBadAuthenticationMethod, nil
+	}
+	rcode, data := c.server.opts.authenticator.Authenticate(c.info, cr)
+	for rcode == packet.RCContinueAuthentication {
+		if method == "" {
+			return packet.RCNotAuthorized, nil
+		}
+		c.send(&packet.Authentication{
+			ReasonCode: packet.RCContinueAuthentication,
+			Properties: &packet.AuthenticationProperties{AuthenticationMethod: method, AuthenticationData: data},
+		})
+		_, p, err := c.reader.ReadPacket()
+		if err != nil {
+			return 0, err
+		}
+		auth, ok := p.(*packet.Authentication)
+		if !ok || auth.Validate() != packet.RCSuccess || auth.ValidateDirection(packet.ClientToServer) != packet.RCSuccess {
+			return packet.RCProtocolError, nil
+		}
+		if auth.ReasonCode != packet.RCContinueAuthentication || auth.Method() != method {
+			return packet.RCProtocolError, nil
+		}
+		rcode, data = enhanced.AuthenticateContinue(c.info, auth)
+	}
+	if rcode == packet.RCSuccess && method != "" {
+		connack.Properties.AuthenticationMethod = method
+		connack.Properties.AuthenticationData = data
+	}
+	return rcode, nil
+}
+
+// authMethod returns the authentication method of the CONNECT, empty without enhanced authentication.
+func (c *client) authMethod() string {
+	if c.connect.Properties == nil {
+		return ""
+	}
+	return c.connect.Properties.AuthenticationMethod
+}
+
+// readLoop reads and handles packets until the connection fails or the client disconnects.
+func (c *client) readLoop() error {
+	for {
+		_, p, err := c.reader.ReadPacket()
+		if err != nil {
+			return err
+		}
+		c.keepalive.Reset()
+		err = c.handle(p)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handle dispatches a packet received from the client.
+func (c *client) handle(p packet.Packet) error {
+	switch p := p.(type) {
+	case *packet.PublishMessage:
+		return c.handlePublish(p)
+	case *packet.PublishAcknowledgement:
+		c.session.puback(p.PacketID)
+	case *packet.PublishReceived:
+		return c.handlePublishReceived(p)
+	case *packet.PublishRelease:
+		return c.handlePublishRelease(p)
+	case *packet.PublishComplete:
+		c.session.pubcomp(p.PacketID)
+	case *packet.SubscribeRequest:
+		return c.handleSubscribe(p)
+	case *packet.UnsubscribeRequest:
+		return c.handleUnsubscribe(p)
+	case *packet.PingRequest:
+		c.send(&packet.PingResponse{})
+	case *packet.Disconnect:
+		return c.handleDisconnect(p)
+	case *packet.Authentication:
+		return c.handleAuth(p)
+	case *packet.ConnectionRequest: // [MQTT-3.1.0-2]
+		return packet.RCProtocolError
+	case *packet.ConnectAcknowledgement, *packet.SubscribeAcknowledgement,
+		*packet.UnsubscribeAcknowledgement, *packet.PingResponse:
+		return packet.RCProtocolError
+	}
+	return nil
+}
+
+// handlePublish routes a message published by the client and acknowledges it.
+func (c *client) handlePublish(pm *packet.PublishMessage) error {
+	if rcode := pm.Validate(); rcode != packet.RCSuccess {
+		return rcode
+	}
+	if rcode := c.aliases.resolve(pm, c.server.opts.topicAliasMaximum); rcode != packet.RCSuccess {
+		return rcode
+	}
+	if len(pm.Properties.SubscriptionIdentifier) > 0 { // [MQTT-3.3.4-6]
+		return packet.RCProtocolError
+	}
+	pm.TopicName = c.mount(pm.TopicName)
+	if pm.Retain && !c.server.opts.retainAvailable && c.ver == packet.ProtoVer5 {
+		return packet.RCRetainNotSupported
+	}
+	if pm.QoSLevel > c.server.opts.maximumQoS {
+		return packet.RCQoSNotSupported
+	}
+	if pm.QoSLevel == packet.QoS2 {
+		switch rcode := c.session.receive(pm.PacketID, c.server.opts.receiveMaximum); rcode {
+		case packet.RCReceiveMaximumExceeded: // [MQTT-3.3.4-9]
+			return rcode
+		case packet.RCPacketIDInUse:
+			// a retransmission of a message not released yet is acknowledged without delivering it again [MQTT-4.3.3-10]
+			if pm.DUP {
+				rcode = packet.RCSuccess
+			}
+			c.send(&packet.PublishReceived{PacketID: pm.PacketID, ReasonCode: c.reasonCode(rcode)})
+			return nil
+		}
+	}
+	var rcode packet.RCode
+	switch {
+	case !c.server.opts.authorizer.Authorize(c.info, AccessWrite, pm.TopicName):
+		// clients before v5 cannot be told, so the message is acknowledged and dropped
+		rcode = c.reasonCode(packet.RCNotAuthorized)
+		if rcode != packet.RCSuccess {
+			c.session.release(pm.PacketID)
+		}
+	case c.server.publish(c.id, pm) == 0:
+		rcode = c.reasonCode(packet.RCNoMatchingSubscribers)
+	}
+	switch pm.QoSLevel {
+	case packet.QoS1:
+		c.send(&packet.PublishAcknowledgement{PacketID: pm.PacketID, ReasonCode: rcode})
+	case packet.QoS2:
+		c.send(&packet.PublishReceived{PacketID: pm.PacketID, ReasonCode: rcode})
+	}
+	return nil
+}
+
+// handlePublishReceived answers the PUBREC of a QoS 2 delivery with a PUBREL. [MQTT-4.3.3-4]
+func (c *client) handlePublishReceived(pr *packet.PublishReceived) error {
+	if rcode := pr.Validate(); rcode != packet.RCSuccess {
+		return rcode
+	}
+	if !c.session.pubrec(pr.PacketID, pr.ReasonCode) {
+		c.send(&packet.PublishRelease{PacketID: pr.PacketID, ReasonCode: c.reasonCode(packet.RCPacketIDNotFound)})
+		return nil
+	}
+	if pr.ReasonCode < 0x80 {
+		c.send(&packet.PublishRelease{PacketID: pr.PacketID})
+	}
+	return nil
+}
+
+// handlePublishRelease completes a QoS 2 message from the client with a PUBCOMP. [MQTT-4.3.3-11]
+func (c *client) handlePublishRelease(pr *packet.PublishRelease) error {
+	if rcode := pr.Validate(); rcode != packet.RCSuccess {
+		return rcode
+	}
+	rcode := packet.RCSuccess
+	if !c.session.release(pr.PacketID) {
+		rcode = c.reasonCode(packet.RCPacketIDNotFound)
+	}
+	c.send(&packet.PublishComplete{PacketID: pr.PacketID, ReasonCode: rcode})
+	return nil
+}
+
+// reasonCode returns rcode for v5 clients and RCSuccess for older ones, whose acknowledgements carry no reason.
+func (c *client) reasonCode(rcode packet.RCode) packet.RCode {
+	if c.ver != packet.ProtoVer5 {
+		return packet.RCSuccess
+	}
+	return rcode
+}
+
+// handleSubscribe stores the subscriptions of a SUBSCRIBE and answers with a SUBACK. [MQTT-3.8.4-1]
+func (c *client) handleSubscribe(sr *packet.SubscribeRequest) error {
+	if sr.PacketID == 0 || len(sr.Payload) == 0 { // [MQTT-2.2.1-3] [MQTT-3.8.3-2]
+		return packet.RCProtocolError
+	}
+	if !c.server.opts.subscriptionIDAvailable && sr.Payload[0].SubscriptionID != 0 {
+		return packet.RCSubscriptionIdentifiersNotSupported
+	}
+	suback := &packet.SubscribeAcknowledgement{PacketID: sr.PacketID}
+	var retained []*packet.SubscribePayload
+	for _, sub := range sr.Payload {
+		rcode := sub.Validate()
+		switch {
+		case rcode == packet.RCTopicFilterInvalid:
+		case rcode != packet.RCSuccess:
+			return rcode
+		case !c.server.opts.sharedSubAvailable && packet.IsSharedSubscription(sub.TopicFilter):
+			rcode = packet.RCSharedSubscriptionsNotSupported
+		case !c.server.opts.wildcardSubAvailable && packet.HasWildcard(subscriptionFilter(sub.TopicFilter)):
+			rcode = packet.RCWildcardSubscriptionsNotSupported
+		case !c.server.opts.authorizer.Authorize(c.info, AccessRead, subscriptionFilter(c.mount(sub.TopicFilter))):
+			rcode = packet.RCNotAuthorized
+		default:
+			sub.TopicFilter = c.mount(sub.TopicFilter)
+			sub.QoS = min(sub.QoS, c.server.opts.maximumQoS)
+			existed := c.server.broker.subscribe(c.id, sub)
+			c.session.subscribe(sub)
+			rcode = packet.GrantedQoS(sub.QoS)
+			// retained messages are not sent for shared subscriptions [MQTT-3.3.1-9] [MQTT-3.3.1-10] [MQTT-3.3.1-11]
+			if packet.IsSharedSubscription(sub.TopicFilter) {
+				break
+			}
+			if sub.RetainHandling == packet.RetainHandlingSend ||
+				sub.RetainHandling == packet.RetainHandlingSendWhenNotExist && !existed {
+				retained = append(retained, sub)
+			}
+		}
+		suback.ReasonCodes = append(suback.ReasonCodes, rcode)
+	}
+	c.send(suback)
+	for _, sub := range retained {
+		c.sendRetained(sub)
+	}
+	return nil
+}
+
+// sendRetained forwards the retained messages matching a new subscription, with the RETAIN flag set. [MQTT-3.3.1-9]
+func (c *client) sendRetained(sub *packet.SubscribePayload) {
+	if !c.server.opts.retainAvailable {
+		return
+	}
+	d := &delivery{clientID: c.id, qos: sub.QoS, retainAsPublished: true, subscriptionIDs: subscriptionIDs(sub)}
+	for _, msg := range c.server.retained.match(sub.TopicFilter) {
+		c.session.deliver(&message{pm: msg.pm, expiresAt: msg.expiresAt, delivery: d}, c.server.opts.maxQueued)
+	}
+}
+
+// handleUnsubscribe removes the subscriptions of an UNSUBSCRIBE and answers with an UNSUBACK. [MQTT-3.10.4-4]
+func (c *client) handleUnsubscribe(ur *packet.UnsubscribeRequest) error {
+	if ur.PacketID == 0 || len(ur.TopicFilters) == 0 { // [MQTT-2.2.1-3] [MQTT-3.10.3-2]
+		return packet.RCProtocolError
+	}
+	unsuback := &packet.UnsubscribeAcknowledgement{PacketID: ur.PacketID}
+	for _, filter := range ur.TopicFilters {
+		filter = c.mount(filter)
+		rcode := packet.RCNoSubscriptionExisted
+		if c.server.broker.unsubscribe(c.id, filter) {
+			c.session.unsubscribe(filter)
+			rcode = packet.RCSuccess
+		}
+		unsuback.ReasonCodes = append(unsuback.ReasonCodes, rcode)
+	}
+	c.send(unsuback)
+	return nil
+}
+
+// handleAuth runs the re-authentication of a client that connected with enhanced authentication,
+// while it keeps exchanging other packets. A failed re-authentication closes the connection. [MQTT-4.12.1-2]
+func (c *client) handleAuth(auth *packet.Authentication) error {
+	if c.ver != packet.ProtoVer5 {
+		return packet.RCMalformedPacket
+	}
+	if rcode := auth.ValidateDirection(packet.ClientToServer); rcode != packet.RCSuccess {
+		return rcode
+	}
+	method := c.authMethod()
+	enhanced, ok := c.server.opts.authenticator.(EnhancedAuthenticator)
+	if method == "" || !ok || auth.Method() != method { // [MQTT-4.12.1-1]
+		return packet.RCProtocolError
+	}
+	switch {
+	case auth.ReasonCode == packet.RCReAuthenticate && !c.reauthenticating:
+		c.reauthenticating = true
+	case auth.ReasonCode == packet.RCContinueAuthentication && c.reauthenticating:
+	default:
+		return packet.RCProtocolError
+	}
+
+	rcode, data := enhanced.AuthenticateContinue(c.info, auth)
+	switch rcode {
+	case packet.RCSuccess:
+		c.reauthenticating = false
+	case packet.RCContinueAuthentication:
+	default:
+		return rcode
+	}
+	c.send(&packet.Authentication{
+		ReasonCode: rcode,
+		Properties: &packet.AuthenticationProperties{AuthenticationMethod: method, AuthenticationData: data},
+	})
+	return nil
+}
+
+// handleDisconnect applies the session expiry interval of a DISCONNECT before the connection closes.
+// Only a normal disconnection discards the will message.
+func (c *client) handleDisconnect(d *packet.Disconnect) error {
+	if rcode := d.ValidateDirection(packet.ClientToServer); rcode != packet.RCSuccess {
+		return rcode
+	}
+	if d.Properties != nil && d.Properties.SessionExpiryInterval.Flag() {
+		expiry := d.Properties.SessionExpiryInterval.Value()
+		if c.session.ExpiryInterval() == 0 && expiry != 0 { // [MQTT-3.14.2-2]
+			return packet.RCProtocolError
+		}
+		c.session.setExpiryInterval(expiry)
+	}
+	if d.ReasonCode == packet.RCNormalDisconnection { // [MQTT-3.1.2-10]
+		c.will = nil
+	}
+	return errClientDisconnected
+}
+
+// fits reports whether a packet is within the Maximum Packet Size of the client.
+func (c *client) fits(p packet.Packet) bool {
+	if c.maximumPacketSize == 0 {
+		return true
+	}
+	size, err := packet.PacketSize(c.ver, c.unmount(p))
+	return err == nil && size <= c.maximumPacketSize
+}
+
+// trySend queues a packet unless the queue is full. It returns whether the packet was queued.
+func (c *client) trySend(p packet.Packet) bool {
+	select {
+	case c.outbound <- p:
+		return true
+	default:
+		return false
+	}
+}
+
+// send queues a packet for the write loop. It returns false if the connection is closing.
+func (c *client) send(p packet.Packet) bool {
+	select {
+	case c.outbound <- p:
+		return true
+	case <-c.closing:
+		return false
+	}
+}
+
+func (c *client) writeLoop() {
+	defer close(c.writerDone)
+	defer c.conn.Close()
+	for {
+		select {
+		case p := <-c.outbound:
+			err := c.write(p)
+			if err != nil {
+				c.close()
+				return
+			}
+			if c.congested.CompareAndSwap(true, false) {
+				c.session.resume(c)
+			}
+		case <-c.closing:
+			c.flush()
+			return
+		}
+	}
+}
+
+// write writes a packet to the connection, replacing the topic name of a PUBLISH with an alias when possible.
+func (c *client) write(p packet.Packet) error {
+	p = c.unmount(p)
+	if pm, ok := p.(*packet.PublishMessage); ok && c.outAliases != nil {
+		p = c.outAliases.apply(pm)
+	}
+	return c.writer.WritePacket(c.ver, p)
+}
+
+// flush writes the queued packets and, if the server closes the connection, the DISCONNECT telling why,
+// until the write deadline set by stop.
+func (c *client) flush() {
+	for {
+		select {
+		case p := <-c.outbound:
+			err := c.write(p)
+			if err != nil {
+				return
+			}
+		default:
+			if c.notify {
+				c.writer.WritePacket(c.ver, &packet.Disconnect{ReasonCode: c.reason})
+			}
+			return
+		}
+	}
+}
+
+// disconnect closes the connection from the server side, telling v5 clients the reason. [MQTT-4.13.2-1]
+func (c *client) disconnect(reason packet.RCode) {
+	c.stopOnce.Do(func() {
+		c.reason = reason
+		// only a v5 client that received a CONNACK may receive a DISCONNECT [MQTT-3.14.0-1]
+		c.notify = c.connected.Load() && c.ver == packet.ProtoVer5
+		c.stop()
+	})
+}
+
+// close closes the connection without a DISCONNECT.
+func (c *client) close() {
+	c.stopOnce.Do(c.stop)
+}
+
+// stop tells the write loop to flush and close the connection. The write deadline also ends a write blocked
+// on a peer that does not read, which would otherwise keep the write loop from seeing c.closing.
+func (c *client) stop() {
+	c.conn.SetWriteDeadline(time.Now().Add(flushTimeout))
+	close(c.closing)
+}
+
+// stopWith closes the connection after the read loop ended with err.
+func (c *client) stopWith(err error) {
+	var rcode packet.RCode
+	switch {
+	case err == nil, errors.Is(err, errClientDisconnected), errors.Is(err, errNotConnected):
+		c.close()
+	case errors.As(err, &rcode):
+		c.disconnect(rcode)
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, net.ErrClosed):
+		c.close()
+	default:
+		var netErr net.Error
+		if errors.As(err, &netErr) {
+			c.close()
+			return
+		}
+		c.disconnect(packet.RCMalformedPacket)
+	}
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+// Config is the configuration of a server as read from a JSON file by LoadConfig.
+// Fields left out keep the defaults of NewServer.
+type Config struct {
+	Listeners []ListenerSpec `json:"listeners"`
+
+	ConnectTimeout    *uint32 `json:"connect_timeout"`     // seconds
+	ServerKeepAlive   *uint16 `json:"server_keep_alive"`   // seconds, 0 keeps the client's value
+	MaxQueuedMessages *int    `json:"max_queued_messages"` // per session
+
+	MaximumQoS        *packet.QoS `json:"maximum_qos"`
+	MaximumPacketSize *uint32     `json:"maximum_packet_size"` // bytes, 0 only applies the protocol limit
+	ReceiveMaximum    *uint16     `json:"receive_maximum"`
+	TopicAliasMaximum *uint16     `json:"topic_alias_maximum"`
+
+	RetainAvailable                 *bool `json:"retain_available"`
+	WildcardSubscriptionAvailable   *bool `json:"wildcard_subscription_available"`
+	SharedSubscriptionAvailable     *bool `json:"shared_subscription_available"`
+	SubscriptionIdentifierAvailable *bool `json:"subscription_identifier_available"`
+
+	// ShareStrategy is "round_robin", "random", "sticky" or "hash".
+	ShareStrategy string `json:"share_strategy"`
+
+	Plugins PluginConfig `json:"plugins"`
+}
+
+// ListenerSpec is a listener in a Config.
+type ListenerSpec struct {
+	// Type is "tcp", "tls", "websocket" or "unix".
+	Type string `json:"type"`
+	// Address is the TCP address, or the path of a Unix socket.
+	Address string     `json:"address"`
+	TLS     *TLSConfig `json:"tls"` // required by "tls" listeners
+
+	MaxConnections   int                      `json:"max_connections"`
+	ProtocolVersions []packet.ProtocolVersion `json:"protocol_versions"` // 3, 4 or 5
+	Mountpoint       string                   `json:"mountpoint"`
+}
+
+// PluginConfig configures the authentication and authorization hooks of a Config.
+// Every client is accepted and authorized without them.
+type PluginConfig struct {
+	// PasswordFile authenticates clients with NewPasswordFileAuthenticator.
+	PasswordFile string `json:"password_file"`
+	// CertificateAuth accepts the clients that presented a verified certificate with NewCertificateAuthenticator,
+	// authenticating the others with PasswordFile if it is set. The username of a client with a certificate
+	// is the identity of the certificate, so ACLFile rules for a user or %u apply to that identity.
+	CertificateAuth bool `json:"certificate_auth"`
+	// ACLFile authorizes clients with NewACLFileAuthorizer.
+	ACLFile string `json:"acl_file"`
+}
+
+var shareStrategies = map[string]ShareStrategy{
+	"round_robin": ShareRoundRobin,
+	"random":      ShareRandom,
+	"sticky":      ShareSticky,
+	"hash":        ShareHash,
+}
+
+// LoadConfig reads a JSON configuration file. Unknown fields are an error.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config := &Config{}
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Options returns the options of the configuration, to create a server with NewServer.
+// It loads the files of the plugins.
+func (config *Config) Options() ([]option, error) {
+	var opts []option
+	for i, spec := range config.Listeners {
+		opt, err := spec.option()
+		if err != nil {
+			return nil, fmt.Errorf("listener %d: %w", i, err)
+		}
+		opts = append(opts, opt)
+	}
+
+	if config.ConnectTimeout != nil {
+		opts = append(opts, WithConnectTimeout(time.Duration(*config.ConnectTimeout)*time.Second))
+	}
+	if config.ServerKeepAlive != nil {
+		opts = append(opts, WithServerKeepAlive(*config.ServerKeepAlive))
+	}
+	if config.MaxQueuedMessages != nil {
+		if *config.MaxQueuedMessages < 0 {
+			return nil, fmt.Errorf("invalid max_queued_messages %d", *config.MaxQueuedMessages)
+		}
+		opts = append(opts, WithMaxQueuedMessages(*config.MaxQueuedMessages))
+	}
+	if config.MaximumQoS != nil {
+		if *config.MaximumQoS > packet.QoS2 {
+			return nil, fmt.Errorf("invalid maximum_qos %d", *config.MaximumQoS)
+		}
+		opts = append(opts, WithMaximumQoS(*config.MaximumQoS))
+	}
+	if config.MaximumPacketSize != nil {
+		opts = append(opts, WithMaximumPacketSize(*config.MaximumPacketSize))
+	}
+	if config.ReceiveMaximum != nil {
+		if *config.ReceiveMaximum == 0 {
+			return nil, errors.New("invalid receive_maximum 0")
+		}
+		opts = append(opts, WithReceiveMaximum(*config.ReceiveMaximum))
+	}
+	if config.TopicAliasMaximum != nil {
+		opts = append(opts, WithTopicAliasMaximum(*config.TopicAliasMaximum))
+	}
+
+	if config.RetainAvailable != nil {
+		opts = append(opts, WithRetainAvailable(*config.RetainAvailable))
+	}
+	if config.WildcardSubscriptionAvailable != nil {
+		opts = append(opts, WithWildcardSubscriptionAvailable(*config.WildcardSubscriptionAvailable))
+	}
+	if config.SharedSubscriptionAvailable != nil {
+		opts = append(opts, WithSharedSubscriptionAvailable(*config.SharedSubscriptionAvailable))
+	}
+	if config.SubscriptionIdentifierAvailable != nil {
+		opts = append(opts, WithSubscriptionIdentifierAvailable(*config.SubscriptionIdentifierAvailable))
+	}
+	if config.ShareStrategy != "" {
+		strategy, ok := shareStrategies[config.ShareStrategy]
+		if !ok {
+			return nil, fmt.Errorf("invalid share_strategy %q", config.ShareStrategy)
+		}
+		opts = append(opts, WithShareStrategy(strategy))
+	}
+
+	pluginOpts, err := config.Plugins.options()
+	if err != nil {
+		return nil, err
+	}
+	return append(opts, pluginOpts...), nil
+}
+
+func (spec *ListenerSpec) option() (option, error) {
+	lc := ListenerConfig{MaxConnections: spec.MaxConnections, ProtocolVersions: spec.ProtocolVersions, Mountpoint: spec.Mountpoint}
+	for _, ver := range spec.ProtocolVersions {
+		if !ver.IsValid() {
+			return nil, fmt.Errorf("invalid protocol version %d", ver)
+		}
+	}
+	if err := lc.validate(); err != nil {
+		return nil, err
+	}
+	if spec.Address == "" {
+		return nil, errors.New("missing address")
+	}
+	switch spec.Type {
+	case "tcp":
+		return WithTCPListener(spec.Address, lc), nil
+	case "tls":
+		if spec.TLS == nil {
+			return nil, errors.New("missing tls settings")
+		}
+		if err := spec.TLS.validate(); err != nil {
+			return nil, err
+		}
+		return WithTLSListener(spec.Address, *spec.TLS, lc), nil
+	case "websocket":
+		return WithWebSocketListener(spec.Address, lc), nil
+	case "unix":
+		return WithUnixSocketListener(spec.Address, lc), nil
+	}
+	return nil, fmt.Errorf("invalid type %q", spec.Type)
+}
+
+func (pc *PluginConfig) options() ([]option, error) {
+	var opts []option
+	var auth Authenticator
+	if pc.PasswordFile != "" {
+		var err error
+		if auth, err = NewPasswordFileAuthenticator(pc.PasswordFile); err != nil {
+			return nil, err
+		}
+	}
+	if pc.CertificateAuth {
+		auth = NewCertificateAuthenticator(auth)
+	}
+	if auth != nil {
+		opts = append(opts, WithAuthenticator(auth))
+	}
+	if pc.ACLFile != "" {
+		authorizer, err := NewACLFileAuthorizer(pc.ACLFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithAuthorizer(authorizer))
+	}
+	return opts, nil
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	passwd := writeLinesFile(t, "passwd", "alice:"+hash)
+	path := writeConfigFile(t, `{
+		"listeners": [
+			{"type": "tcp", "address": "127.0.0.1:0", "protocol_versions": [5]},
+			{"type": "unix", "address": "`+filepath.Join(t.TempDir(), "mqtt.sock")+`", "mountpoint": "local/"}
+		],
+		"server_keep_alive": 30,
+		"maximum_qos": 1,
+		"maximum_packet_size": 4096,
+		"receive_maximum": 100,
+		"topic_alias_maximum": 0,
+		"retain_available": false,
+		"wildcard_subscription_available": false,
+		"shared_subscription_available": false,
+		"share_strategy": "sticky",
+		"plugins": {"password_file": "`+passwd+`"}
+	}`)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	opts, err := config.Options()
+	if err != nil {
+		t.Fatalf("failed to build options: %v", err)
+	}
+	s := startTestServer(t, opts...)
+
+	props := s.connackProperties()
+	if props.MaximumQoS.Value() != packet.QoS1 || props.ReceiveMaximum != 100 || props.MaximumPacketSize != 4096 ||
+		props.TopicAliasMaximum != 0 || props.RetainAvailable.Value() != 0 || !props.RetainAvailable.Flag() ||
+		!props.WildcardSubscriptionAvailable.Flag() || props.WildcardSubscriptionAvailable.Value() != 0 ||
+		!props.SharedSubscriptionAvailable.Flag() || props.SharedSubscriptionAvailable.Value() != 0 ||
+		props.SubscriptionIdentifierAvailable.Flag() {
+		t.Errorf("unexpected CONNACK properties %v", packet.JSON(props))
+	}
+	if s.opts.serverKeepAlive != 30 || s.opts.shareStrategy != ShareSticky {
+		t.Errorf("expected server keep alive 30 and the sticky strategy")
+	}
+	if addrs := s.Addrs(); len(addrs) != 2 || addrs[1].Network() != "unix" {
+		t.Errorf("expected a TCP and a Unix socket listener but got %v", addrs)
+	}
+
+	tc := dialTestConn(t, s, packet.ProtoVer5)
+	if connack := tc.connect(&packet.ConnectionRequest{ClientID: "c"}); connack.ConnectReasonCode != packet.RCBadUsernameOrPassword {
+		t.Errorf("expected the password file to refuse an anonymous client but got %v", connack.ConnectReasonCode)
+	}
+}
+
+func TestLoadConfigCertificatePlugins(t *testing.T) {
+	pki := newTestPKI(t, true)
+	acl := writeLinesFile(t, "acl", "pattern devices/%u/#", "user admin", "topic secret/#")
+	tlsJSON, _ := json.Marshal(pki.config)
+	path := writeConfigFile(t, `{
+		"listeners": [{"type": "tls", "address": "127.0.0.1:0", "tls": `+string(tlsJSON)+`}],
+		"plugins": {"certificate_auth": true, "acl_file": "`+acl+`"}
+	}`)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	opts, err := config.Options()
+	if err != nil {
+		t.Fatalf("failed to build options: %v", err)
+	}
+	s := startTestServer(t, opts...)
+	cert := pki.clientCert(t, "device-1")
+
+	tc := pki.dial(t, s, cert)
+	if connack := tc.connect(&packet.ConnectionRequest{ClientID: "device-1", Username: packet.NewFlagV([]byte("admin"))}); connack.ConnectReasonCode != packet.RCBadUsernameOrPassword {
+		t.Errorf("expected %v for a spoofed username but got %v", packet.RCBadUsernameOrPassword, connack.ConnectReasonCode)
+	}
+	tc = pki.dial(t, s, cert)
+	tc.connect(&packet.ConnectionRequest{ClientID: "device-1"})
+	codes := tc.subscribe(&packet.SubscribePayload{TopicFilter: "secret/#"}, &packet.SubscribePayload{TopicFilter: "devices/device-1/#"})
+	if codes[0] != packet.RCNotAuthorized || codes[1] != packet.RCGrantedQoS0 {
+		t.Errorf("expected the ACL to apply to the certificate identity but got %v", codes)
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	config, err := LoadConfig(writeConfigFile(t, `{}`))
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	opts, err := config.Options()
+	if err != nil {
+		t.Fatalf("failed to build options: %v", err)
+	}
+	if len(opts) != 0 {
+		t.Errorf("expected no option for an empty configuration but got %d", len(opts))
+	}
+	if s, d := NewServer(opts...), NewServer(); packet.JSON(s.connackProperties()) != packet.JSON(d.connackProperties()) {
+		t.Errorf("expected the default CONNACK properties %v but got %v", packet.JSON(d.connackProperties()), packet.JSON(s.connackProperties()))
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []string{
+		`{"unknown": 1}`,
+		`{"max_queued_messages": -1}`,
+		`{"maximum_qos": 3}`,
+		`{"receive_maximum": 0}`,
+		`{"share_strategy": "fastest"}`,
+		`{"listeners": [{"type": "quic", "address": ":1883"}]}`,
+		`{"listeners": [{"type": "tcp"}]}`,
+		`{"listeners": [{"type": "tls", "address": ":8883"}]}`,
+		`{"listeners": [{"type": "tls", "address": ":8883", "tls": {"cert_file": "c.pem", "key_file": "k.pem", "require_client_cert": true}}]}`,
+		`{"listeners": [{"type": "tcp", "address": ":1883", "protocol_versions": [6]}]}`,
+		`{"listeners": [{"type": "tcp", "address": ":1883", "mountpoint": "#"}]}`,
+		`{"plugins": {"acl_file": "missing.acl"}}`,
+	}
+	for _, content := range tests {
+		config, err := LoadConfig(writeConfigFile(t, content))
+		if err == nil {
+			_, err = config.Options()
+		}
+		if err == nil {
+			t.Errorf("expected an error for %s", content)
+		}
+	}
+}
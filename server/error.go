@@ -1 +1,13 @@
 package server
+
+import "errors"
+
+var (
+	ErrServerStarted = errors.New("server already started")
+	ErrServerClosed  = errors.New("server closed")
+)
+
+var (
+	errNotConnected       = errors.New("connection closed before CONNECT completed")
+	errClientDisconnected = errors.New("client sent DISCONNECT")
+)
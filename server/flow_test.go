@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+// expectNothing checks that the server sends no packet for a short while.
+func (tc *testConn) expectNothing() {
+	tc.t.Helper()
+	tc.conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, p, err := tc.reader.ReadPacket(); err == nil {
+		tc.t.Fatalf("expected no packet but got %v", p.Type())
+	}
+	// a timed out read may have consumed part of a packet, so the connection is not read again
+}
+
+func TestFlowControlReceiveMaximum(t *testing.T) {
+	s := startTestServer(t)
+	sub := dialTestConn(t, s, packet.ProtoVer5)
+	sub.connect(&packet.ConnectionRequest{ClientID: "sub", Properties: &packet.ConnectProperties{ReceiveMaximum: 2}})
+	sub.subscribe(&packet.SubscribePayload{TopicFilter: "flow", QoS: packet.QoS1})
+	pub := dialTestConn(t, s, packet.ProtoVer5)
+	pub.connect(&packet.ConnectionRequest{ClientID: "pub"})
+
+	for i := uint16(1); i <= 3; i++ {
+		pub.write(&packet.PublishMessage{QoSLevel: packet.QoS1, PacketID: i, TopicName: "flow", Payload: []byte{byte('0' + i)}})
+		pub.read()
+	}
+	first := sub.readPublish()
+	if second := sub.readPublish(); string(second.Payload) != "2" {
+		t.Fatalf("expected message 2 but got %v", packet.JSON(second))
+	}
+	// the third message waits until an in-flight one is acknowledged
+	sub.write(&packet.PublishAcknowledgement{PacketID: first.PacketID})
+	if third := sub.readPublish(); string(third.Payload) != "3" {
+		t.Errorf("expected message 3 but got %v", packet.JSON(third))
+	}
+}
+
+func TestFlowControlReceiveMaximumHeld(t *testing.T) {
+	s := startTestServer(t)
+	sub := dialTestConn(t, s, packet.ProtoVer5)
+	sub.connect(&packet.ConnectionRequest{ClientID: "sub", Properties: &packet.ConnectProperties{ReceiveMaximum: 1}})
+	sub.subscribe(&packet.SubscribePayload{TopicFilter: "flow", QoS: packet.QoS1})
+	pub := dialTestConn(t, s, packet.ProtoVer5)
+	pub.connect(&packet.ConnectionRequest{ClientID: "pub"})
+
+	for i := uint16(1); i <= 2; i++ {
+		pub.write(&packet.PublishMessage{QoSLevel: packet.QoS1, PacketID: i, TopicName: "flow"})
+		pub.read()
+	}
+	sub.readPublish()
+	sub.expectNothing()
+}
+
+func TestFlowControlReceiveMaximumExceeded(t *testing.T) {
+	s := startTestServer(t, WithReceiveMaximum(1))
+	tc := dialTestConn(t, s, packet.ProtoVer5)
+	if connack := tc.connect(&packet.ConnectionRequest{ClientID: "c"}); connack.Properties.ReceiveMaximum != 1 {
+		t.Fatalf("expected receive maximum 1 but got %d", connack.Properties.ReceiveMaximum)
+	}
+	tc.write(&packet.PublishMessage{QoSLevel: packet.QoS2, PacketID: 1, TopicName: "flow"})
+	if _, ok := tc.read().(*packet.PublishReceived); !ok {
+		t.Fatalf("expected PUBREC")
+	}
+	tc.write(&packet.PublishMessage{QoSLevel: packet.QoS2, PacketID: 2, TopicName: "flow"})
+	if d, ok := tc.read().(*packet.Disconnect); !ok || d.ReasonCode != packet.RCReceiveMaximumExceeded {
+		t.Errorf("expected DISCONNECT with %v", packet.RCReceiveMaximumExceeded)
+	}
+	tc.expectClosed()
+}
+
+func TestFlowControlReceiveMaximumZero(t *testing.T) {
+	s := startTestServer(t, WithReceiveMaximum(0))
+	tc := dialTestConn(t, s, packet.ProtoVer5)
+	if connack := tc.connect(&packet.ConnectionRequest{ClientID: "c"}); connack.Properties.ReceiveMaximum != 0 {
+		t.Fatalf("expected the default receive maximum but got %d", connack.Properties.ReceiveMaximum)
+	}
+	tc.write(&packet.PublishMessage{QoSLevel: packet.QoS2, PacketID: 1, TopicName: "flow"})
+	if rec, ok := tc.read().(*packet.PublishReceived); !ok || rec.ReasonCode >= 0x80 {
+		t.Errorf("expected PUBREC without failure")
+	}
+}
+
+func TestFlowControlMaximumPacketSize(t *testing.T) {
+	s := startTestServer(t, WithMaximumPacketSize(64))
+
+	t.Run("inbound", func(t *testing.T) {
+		tc := dialTestConn(t, s, packet.ProtoVer5)
+		if connack := tc.connect(&packet.ConnectionRequest{ClientID: "big"}); connack.Properties.MaximumPacketSize != 64 {
+			t.Fatalf("expected maximum packet size 64 but got %d", connack.Properties.MaximumPacketSize)
+		}
+		tc.write(&packet.PublishMessage{TopicName: "big", Payload: bytes.Repeat([]byte("x"), 64)})
+		if d, ok := tc.read().(*packet.Disconnect); !ok || d.ReasonCode != packet.RCPacketTooLarge {
+			t.Errorf("expected DISCONNECT with %v", packet.RCPacketTooLarge)
+		}
+		tc.expectClosed()
+	})
+
+	t.Run("outbound", func(t *testing.T) {
+		sub := dialTestConn(t, s, packet.ProtoVer5)
+		sub.connect(&packet.ConnectionRequest{ClientID: "sub", Properties: &packet.ConnectProperties{MaximumPacketSize: 32}})
+		sub.subscribe(&packet.SubscribePayload{TopicFilter: "size", QoS: packet.QoS1})
+		pub := dialTestConn(t, s, packet.ProtoVer5)
+		pub.connect(&packet.ConnectionRequest{ClientID: "pub"})
+
+		pub.write(&packet.PublishMessage{QoSLevel: packet.QoS1, PacketID: 1, TopicName: "size", Payload: bytes.Repeat([]byte("x"), 40)})
+		pub.read()
+		pub.write(&packet.PublishMessage{QoSLevel: packet.QoS1, PacketID: 2, TopicName: "size", Payload: []byte("small")})
+		pub.read()
+		// the large message is discarded without taking a packet id or an in-flight slot
+		if pm := sub.readPublish(); string(pm.Payload) != "small" {
+			t.Errorf("expected only the small message but got %v", packet.JSON(pm))
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+// negotiateKeepalive returns the keep alive the connection runs with and whether the server overrides the client's value.
+// Only v5 clients can be told about a server keep alive, so older clients keep their own value.
+func negotiateKeepalive(clientKeepalive, serverKeepalive uint16, ver packet.ProtocolVersion) (uint16, bool) {
+	if ver != packet.ProtoVer5 || serverKeepalive == 0 || serverKeepalive == clientKeepalive {
+		return clientKeepalive, false
+	}
+	return serverKeepalive, true // [MQTT-3.2.2-21]
+}
+
+// keepaliveTimeout returns how long a connection may stay silent, one and a half times the keep alive. [MQTT-3.1.2-22]
+func keepaliveTimeout(keepalive uint16) time.Duration {
+	return time.Duration(keepalive) * time.Second * 3 / 2
+}
+
+// keepaliveMonitor reports RCKeepAliveTimeout when no control packet is received within the timeout.
+type keepaliveMonitor struct {
+	mu        sync.Mutex
+	timeout   time.Duration
+	timer     *time.Timer
+	stopped   bool
+	onTimeout func(packet.RCode)
+}
+
+// newKeepaliveMonitor starts a monitor. A timeout of 0 disables the monitor.
+func newKeepaliveMonitor(timeout time.Duration, onTimeout func(packet.RCode)) *keepaliveMonitor {
+	m := &keepaliveMonitor{
+		timeout:   timeout,
+		onTimeout: onTimeout,
+	}
+	if m.timeout > 0 {
+		m.timer = time.AfterFunc(m.timeout, m.fire)
+	}
+	return m
+}
+
+func (m *keepaliveMonitor) fire() {
+	m.mu.Lock()
+	if m.stopped {
+		m.mu.Unlock()
+		return
+	}
+	m.stopped = true
+	m.mu.Unlock()
+	m.onTimeout(packet.RCKeepAliveTimeout)
+}
+
+// Reset restarts the timeout after a control packet is received.
+func (m *keepaliveMonitor) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.timer == nil || m.stopped {
+		return
+	}
+	m.timer.Reset(m.timeout)
+}
+
+// Stop stops the monitor without firing.
+func (m *keepaliveMonitor) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopped = true
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+}
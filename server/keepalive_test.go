@@ -0,0 +1,70 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+func TestNegotiateKeepalive(t *testing.T) {
+	caseList := []struct {
+		name           string
+		client         uint16
+		server         uint16
+		ver            packet.ProtocolVersion
+		expectVal      uint16
+		expectOverride bool
+	}{
+		{name: "no server keep alive", client: 60, server: 0, ver: packet.ProtoVer5, expectVal: 60},
+		{name: "server override", client: 60, server: 30, ver: packet.ProtoVer5, expectVal: 30, expectOverride: true},
+		{name: "server override disabled keep alive", client: 0, server: 30, ver: packet.ProtoVer5, expectVal: 30, expectOverride: true},
+		{name: "same value", client: 30, server: 30, ver: packet.ProtoVer5, expectVal: 30},
+		{name: "v3.1.1 keeps client value", client: 60, server: 30, ver: packet.ProtoVer311, expectVal: 60},
+	}
+	for _, c := range caseList {
+		t.Run(c.name, func(t *testing.T) {
+			val, override := negotiateKeepalive(c.client, c.server, c.ver)
+			if val != c.expectVal || override != c.expectOverride {
+				t.Errorf("expected (%d, %v) but got (%d, %v)", c.expectVal, c.expectOverride, val, override)
+			}
+		})
+	}
+	if timeout := keepaliveTimeout(10); timeout != 15*time.Second {
+		t.Errorf("expected %v but got %v", 15*time.Second, timeout)
+	}
+}
+
+func TestKeepaliveMonitor(t *testing.T) {
+	fired := make(chan packet.RCode, 1)
+	m := newKeepaliveMonitor(50*time.Millisecond, func(rcode packet.RCode) {
+		fired <- rcode
+	})
+	for i := 0; i < 4; i++ {
+		time.Sleep(20 * time.Millisecond)
+		m.Reset()
+	}
+	select {
+	case <-fired:
+		t.Fatal("monitor fired while packets were received")
+	default:
+	}
+	select {
+	case rcode := <-fired:
+		if rcode != packet.RCKeepAliveTimeout {
+			t.Errorf("expected %v but got %v", packet.RCKeepAliveTimeout, rcode)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("monitor did not fire")
+	}
+
+	stopped := newKeepaliveMonitor(10*time.Millisecond, func(packet.RCode) {
+		t.Error("stopped monitor fired")
+	})
+	stopped.Stop()
+	disabled := newKeepaliveMonitor(0, func(packet.RCode) {
+		t.Error("disabled monitor fired")
+	})
+	disabled.Reset()
+	time.Sleep(30 * time.Millisecond)
+}
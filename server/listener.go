@@ -0,0 +1,148 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+// Listener is an endpoint the server accepts connections on, declared with WithListener.
+type Listener interface {
+	// Listen opens the endpoint. The server closes the returned listener when it shuts down.
+	Listen() (net.Listener, error)
+	// Config returns the settings of the connections accepted by the listener.
+	Config() ListenerConfig
+}
+
+// ListenerConfig is the settings of the connections accepted by a listener.
+type ListenerConfig struct {
+	// MaxConnections is how many connections the listener keeps open at once, 0 if unlimited.
+	// Connections beyond it are closed as soon as they are accepted.
+	MaxConnections int
+	// ProtocolVersions are the MQTT versions clients may connect with, every version if empty.
+	// Clients of other versions are refused with RCUnsupportedProtocol.
+	ProtocolVersions []packet.ProtocolVersion
+	// Mountpoint is the default prefix of the topic names and filters of the clients, so that clients of
+	// different listeners use separate topic trees. The authenticator may change it through ClientInfo.
+	Mountpoint string
+}
+
+var errInvalidMountpoint = errors.New("mountpoint must not contain wildcards or start with '$'")
+
+func (config *ListenerConfig) validate() error {
+	if !validMountpoint(config.Mountpoint) {
+		return errInvalidMountpoint
+	}
+	return nil
+}
+
+func validMountpoint(mountpoint string) bool {
+	return !packet.HasWildcard(mountpoint) && !strings.HasPrefix(mountpoint, "$")
+}
+
+// netListener listens on a TCP address or a Unix socket.
+type netListener struct {
+	network string
+	address string
+	config  ListenerConfig
+}
+
+func (l *netListener) Listen() (net.Listener, error) {
+	return net.Listen(l.network, l.address)
+}
+
+func (l *netListener) Config() ListenerConfig {
+	return l.config
+}
+
+// tlsListener listens on a TCP address for TLS connections.
+type tlsListener struct {
+	address string
+	tls     TLSConfig
+	config  ListenerConfig
+}
+
+func (l *tlsListener) Listen() (net.Listener, error) {
+	reloader, err := newCertReloader(l.tls)
+	if err != nil {
+		return nil, err
+	}
+	nl, err := net.Listen("tcp", l.address)
+	if err != nil {
+		return nil, err
+	}
+	return reloader.listener(nl), nil
+}
+
+func (l *tlsListener) Config() ListenerConfig {
+	return l.config
+}
+
+// websocketListener listens on a TCP address for WebSocket connections, at any path.
+type websocketListener struct {
+	address string
+	config  ListenerConfig
+}
+
+func (l *websocketListener) Listen() (net.Listener, error) {
+	nl, err := net.Listen("tcp", l.address)
+	if err != nil {
+		return nil, err
+	}
+	return newUpgradeListener(nl), nil
+}
+
+func (l *websocketListener) Config() ListenerConfig {
+	return l.config
+}
+
+// endpoint is a listener of a running server.
+type endpoint struct {
+	net.Listener // nil for the connections of a WebSocketHandler
+	config       ListenerConfig
+	conns        atomic.Int64 // open connections
+}
+
+// acquire counts a new connection. It returns false if the listener has reached its maximum.
+func (e *endpoint) acquire() bool {
+	if n := e.conns.Add(1); e.config.MaxConnections > 0 && n > int64(e.config.MaxConnections) {
+		e.conns.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (e *endpoint) release() {
+	e.conns.Add(-1)
+}
+
+// allows reports whether clients may connect to the listener with a protocol version.
+func (e *endpoint) allows(ver packet.ProtocolVersion) bool {
+	return len(e.config.ProtocolVersions) == 0 || slices.Contains(e.config.ProtocolVersions, ver)
+}
+
+// mount returns a topic name or filter of the client under its mountpoint.
+func (c *client) mount(topic string) string {
+	if c.mountpoint == "" {
+		return topic
+	}
+	if group, filter, ok := packet.ParseSharedSubscription(topic); ok {
+		return packet.SharedPrefix + packet.TopicSeparator + group + packet.TopicSeparator + c.mountpoint + filter
+	}
+	return c.mountpoint + topic
+}
+
+// unmount removes the mountpoint of the client from the topic name of a PUBLISH sent to it.
+func (c *client) unmount(p packet.Packet) packet.Packet {
+	pm, ok := p.(*packet.PublishMessage)
+	if !ok || c.mountpoint == "" {
+		return p
+	}
+	out := *pm
+	out.TopicName = strings.TrimPrefix(pm.TopicName, c.mountpoint)
+	return &out
+}
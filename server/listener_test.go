@@ -0,0 +1,101 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+func TestServerListeners(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "mqtt.sock")
+	s := startTestServer(t,
+		WithTCPListener("127.0.0.1:0", ListenerConfig{}),
+		WithUnixSocketListener(socket, ListenerConfig{ProtocolVersions: []packet.ProtocolVersion{packet.ProtoVer5}}),
+		WithTCPListener("127.0.0.1:0", ListenerConfig{MaxConnections: 1}),
+	)
+	addrs := s.Addrs()
+	if len(addrs) != 3 || addrs[1].Network() != "unix" {
+		t.Fatalf("expected a TCP, a Unix socket and a TCP address but got %v", addrs)
+	}
+
+	t.Run("several endpoints", func(t *testing.T) {
+		sub := dialTestAddr(t, addrs[0], packet.ProtoVer5)
+		sub.connect(&packet.ConnectionRequest{ClientID: "tcp"})
+		sub.subscribe(&packet.SubscribePayload{TopicFilter: "listeners/#"})
+		pub := dialTestAddr(t, addrs[1], packet.ProtoVer5)
+		pub.connect(&packet.ConnectionRequest{ClientID: "unix"})
+		pub.write(&packet.PublishMessage{TopicName: "listeners/a", Payload: []byte("hello")})
+		if pm := sub.readPublish(); pm.TopicName != "listeners/a" {
+			t.Errorf("expected listeners/a but got %v", packet.JSON(pm))
+		}
+	})
+
+	t.Run("protocol versions", func(t *testing.T) {
+		tc := dialTestAddr(t, addrs[1], packet.ProtoVer311)
+		if connack := tc.connect(&packet.ConnectionRequest{ClientID: "v311", CleanStart: packet.NewFlagV(true)}); connack.ConnectReasonCode != packet.RCUnsupportedProtocol {
+			t.Errorf("expected %v but got %v", packet.RCUnsupportedProtocol, connack.ConnectReasonCode)
+		}
+		tc.expectClosed()
+	})
+
+	t.Run("max connections", func(t *testing.T) {
+		first := dialTestAddr(t, addrs[2], packet.ProtoVer5)
+		first.connect(&packet.ConnectionRequest{ClientID: "first"})
+		dialTestAddr(t, addrs[2], packet.ProtoVer5).expectClosed()
+
+		first.write(&packet.Disconnect{})
+		first.expectClosed()
+		// the slot is released once the server has closed the first connection
+		deadline := time.Now().Add(2 * time.Second)
+		for s.endpoints[2].conns.Load() != 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		tc := dialTestAddr(t, addrs[2], packet.ProtoVer5)
+		if connack := tc.connect(&packet.ConnectionRequest{ClientID: "second"}); connack.ConnectReasonCode != packet.RCSuccess {
+			t.Errorf("expected %v but got %v", packet.RCSuccess, connack.ConnectReasonCode)
+		}
+	})
+}
+
+func TestServerMountpoint(t *testing.T) {
+	s := startTestServer(t,
+		WithTCPListener("127.0.0.1:0", ListenerConfig{}),
+		WithTCPListener("127.0.0.1:0", ListenerConfig{Mountpoint: "tenant/a/"}),
+	)
+	addrs := s.Addrs()
+
+	global := dialTestAddr(t, addrs[0], packet.ProtoVer5)
+	global.connect(&packet.ConnectionRequest{ClientID: "global"})
+	global.subscribe(&packet.SubscribePayload{TopicFilter: "tenant/#"})
+	mounted := dialTestAddr(t, addrs[1], packet.ProtoVer5)
+	mounted.connect(&packet.ConnectionRequest{ClientID: "mounted"})
+	mounted.subscribe(&packet.SubscribePayload{TopicFilter: "#"}, &packet.SubscribePayload{TopicFilter: "$share/g/jobs"})
+
+	mounted.write(&packet.PublishMessage{TopicName: "x", Payload: []byte("1")})
+	if pm := global.readPublish(); pm.TopicName != "tenant/a/x" {
+		t.Errorf("expected the message of the mounted client on tenant/a/x but got %v", packet.JSON(pm))
+	}
+	if pm := mounted.readPublish(); pm.TopicName != "x" {
+		t.Errorf("expected the mounted client to receive its own message on x but got %v", packet.JSON(pm))
+	}
+
+	global.write(&packet.PublishMessage{TopicName: "tenant/a/jobs", Payload: []byte("2")})
+	global.readPublish()
+	for range 2 { // through # and the shared subscription
+		if pm := mounted.readPublish(); pm.TopicName != "jobs" {
+			t.Errorf("expected jobs but got %v", packet.JSON(pm))
+		}
+	}
+
+	global.write(&packet.PublishMessage{TopicName: "other/x", Payload: []byte("3")})
+	mounted.write(&packet.PingRequest{})
+	if _, ok := mounted.read().(*packet.PingResponse); !ok {
+		t.Errorf("expected no message outside the mountpoint")
+	}
+
+	if err := NewServer(WithTCPListener("127.0.0.1:0", ListenerConfig{Mountpoint: "tenant/+/"})).Start(); err == nil {
+		t.Errorf("expected Start to fail with a wildcard in the mountpoint")
+	}
+}
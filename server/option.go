@@ -1,8 +1,200 @@
 package server
 
+import (
+	"math"
+	"time"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
 type options struct {
+	address                 string
+	listeners               []Listener
+	connectTimeout          time.Duration
+	serverKeepAlive         uint16
+	sessionStore            SessionStore
+	maxQueued               int
+	retainAvailable         bool
+	maximumQoS              packet.QoS
+	wildcardSubAvailable    bool
+	sharedSubAvailable      bool
+	authenticator           Authenticator
+	authorizer              Authorizer
+	shareStrategy           ShareStrategy
+	topicAliasMaximum       uint16
+	receiveMaximum          uint16
+	maximumPacketSize       uint32
+	subscriptionIDAvailable bool
+}
+
+func defaultOptions() options {
+	return options{
+		address:                 ":1883",
+		connectTimeout:          10 * time.Second,
+		maxQueued:               1000,
+		retainAvailable:         true,
+		maximumQoS:              packet.QoS2,
+		wildcardSubAvailable:    true,
+		sharedSubAvailable:      true,
+		authenticator:           NewAllowAllAuthenticator(),
+		authorizer:              NewAllowAllAuthorizer(),
+		topicAliasMaximum:       10,
+		receiveMaximum:          math.MaxUint16,
+		subscriptionIDAvailable: true,
+	}
 }
 
 type option interface {
 	apply(*options)
 }
+
+type optionFunc func(*options)
+
+func (f optionFunc) apply(o *options) {
+	f(o)
+}
+
+// WithAddress sets the TCP address the server listens on when no listener is set.
+func WithAddress(address string) option {
+	return optionFunc(func(o *options) {
+		o.address = address
+	})
+}
+
+// WithListener adds a listener to the server. It replaces the TCP listener on the address set by WithAddress.
+func WithListener(l Listener) option {
+	return optionFunc(func(o *options) {
+		o.listeners = append(o.listeners, l)
+	})
+}
+
+// WithTCPListener adds a listener of MQTT connections on a TCP address.
+func WithTCPListener(address string, config ListenerConfig) option {
+	return WithListener(&netListener{network: "tcp", address: address, config: config})
+}
+
+// WithTLSListener adds a listener of MQTT over TLS connections on a TCP address.
+// The certificate files are loaded again when they change.
+func WithTLSListener(address string, tls TLSConfig, config ListenerConfig) option {
+	return WithListener(&tlsListener{address: address, tls: tls, config: config})
+}
+
+// WithWebSocketListener adds a listener of MQTT over WebSocket connections on a TCP address, at any path.
+func WithWebSocketListener(address string, config ListenerConfig) option {
+	return WithListener(&websocketListener{address: address, config: config})
+}
+
+// WithUnixSocketListener adds a listener of MQTT connections on a Unix domain socket.
+func WithUnixSocketListener(path string, config ListenerConfig) option {
+	return WithListener(&netListener{network: "unix", address: path, config: config})
+}
+
+// WithConnectTimeout sets how long a new connection may take to send its CONNECT packet.
+func WithConnectTimeout(timeout time.Duration) option {
+	return optionFunc(func(o *options) {
+		o.connectTimeout = timeout
+	})
+}
+
+// WithServerKeepAlive overrides the keep alive requested by v5 clients, in seconds. 0 keeps the client's value.
+func WithServerKeepAlive(keepalive uint16) option {
+	return optionFunc(func(o *options) {
+		o.serverKeepAlive = keepalive
+	})
+}
+
+// WithSessionStore sets where sessions are kept. Sessions are kept in memory by default.
+func WithSessionStore(store SessionStore) option {
+	return optionFunc(func(o *options) {
+		o.sessionStore = store
+	})
+}
+
+// WithMaxQueuedMessages sets how many messages a session queues while its client is disconnected.
+func WithMaxQueuedMessages(n int) option {
+	return optionFunc(func(o *options) {
+		o.maxQueued = n
+	})
+}
+
+// WithRetainAvailable sets whether the server keeps retained messages.
+func WithRetainAvailable(available bool) option {
+	return optionFunc(func(o *options) {
+		o.retainAvailable = available
+	})
+}
+
+// WithAuthenticator sets how clients are authenticated. Every client is accepted by default.
+func WithAuthenticator(authenticator Authenticator) option {
+	return optionFunc(func(o *options) {
+		o.authenticator = authenticator
+	})
+}
+
+// WithAuthorizer sets which topics clients may publish and subscribe to. Every topic is allowed by default.
+func WithAuthorizer(authorizer Authorizer) option {
+	return optionFunc(func(o *options) {
+		o.authorizer = authorizer
+	})
+}
+
+// WithShareStrategy sets how shared subscriptions distribute messages among their members. Members take turns by default.
+func WithShareStrategy(strategy ShareStrategy) option {
+	return optionFunc(func(o *options) {
+		o.shareStrategy = strategy
+	})
+}
+
+// WithTopicAliasMaximum sets the highest topic alias v5 clients may use. 0 disables topic aliases from clients.
+func WithTopicAliasMaximum(maximum uint16) option {
+	return optionFunc(func(o *options) {
+		o.topicAliasMaximum = maximum
+	})
+}
+
+// WithReceiveMaximum limits the QoS 1 and 2 messages each client may have in flight to the server.
+// 0 is not a valid Receive Maximum, so it keeps the default of 65535.
+func WithReceiveMaximum(maximum uint16) option {
+	return optionFunc(func(o *options) {
+		if maximum == 0 {
+			maximum = math.MaxUint16
+		}
+		o.receiveMaximum = maximum
+	})
+}
+
+// WithMaximumPacketSize limits the size of the packets clients may send, in bytes. 0 only applies the protocol limit.
+func WithMaximumPacketSize(size uint32) option {
+	return optionFunc(func(o *options) {
+		o.maximumPacketSize = size
+	})
+}
+
+// WithSubscriptionIdentifierAvailable sets whether clients may set subscription identifiers. Enabled by default.
+func WithSubscriptionIdentifierAvailable(available bool) option {
+	return optionFunc(func(o *options) {
+		o.subscriptionIDAvailable = available
+	})
+}
+
+// WithMaximumQoS sets the highest QoS clients may publish with. Subscriptions are granted at most this QoS.
+// QoS 2 is supported by default.
+func WithMaximumQoS(qos packet.QoS) option {
+	return optionFunc(func(o *options) {
+		o.maximumQoS = qos
+	})
+}
+
+// WithWildcardSubscriptionAvailable sets whether clients may subscribe to topic filters with wildcards. Enabled by default.
+func WithWildcardSubscriptionAvailable(available bool) option {
+	return optionFunc(func(o *options) {
+		o.wildcardSubAvailable = available
+	})
+}
+
+// WithSharedSubscriptionAvailable sets whether clients may make shared subscriptions. Enabled by default.
+func WithSharedSubscriptionAvailable(available bool) option {
+	return optionFunc(func(o *options) {
+		o.sharedSubAvailable = available
+	})
+}
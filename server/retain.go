@@ -0,0 +1,153 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+// retainedStore keeps the last retained message of each topic, indexed by topic levels. [MQTT-3.3.1-5]
+type retainedStore struct {
+	mu   sync.RWMutex
+	root *retainedNode
+}
+
+type retainedNode struct {
+	children map[string]*retainedNode
+	message  *retainedMessage
+}
+
+type retainedMessage struct {
+	pm        *packet.PublishMessage
+	expiresAt time.Time // zero if the message does not expire
+}
+
+func newRetainedStore() *retainedStore {
+	return &retainedStore{root: &retainedNode{children: map[string]*retainedNode{}}}
+}
+
+// retain stores a copy of a retained message, or clears the topic if the payload is empty. [MQTT-3.3.1-6] [MQTT-3.3.1-7]
+func (rs *retainedStore) retain(pm *packet.PublishMessage) {
+	if len(pm.Payload) == 0 {
+		rs.clear(pm.TopicName)
+		return
+	}
+	msg := &retainedMessage{pm: &packet.PublishMessage{
+		QoSLevel:   pm.QoSLevel,
+		Retain:     true,
+		TopicName:  pm.TopicName,
+		Properties: pm.Properties,
+		Payload:    pm.Payload,
+	}}
+	msg.pm.Properties.TopicAlias = 0
+	msg.expiresAt = expiryTime(pm, time.Now())
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	n := rs.root
+	for _, level := range strings.Split(pm.TopicName, packet.TopicSeparator) {
+		child, ok := n.children[level]
+		if !ok {
+			child = &retainedNode{children: map[string]*retainedNode{}}
+			n.children[level] = child
+		}
+		n = child
+	}
+	n.message = msg
+}
+
+// clear removes the retained message of a topic.
+func (rs *retainedStore) clear(topic string) {
+	rs.remove(topic, nil)
+}
+
+// remove removes the retained message of a topic if it is msg, or whichever it is if msg is nil.
+func (rs *retainedStore) remove(topic string, msg *retainedMessage) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	levels := strings.Split(topic, packet.TopicSeparator)
+	path := []*retainedNode{rs.root}
+	n := rs.root
+	for _, level := range levels {
+		n = n.children[level]
+		if n == nil {
+			return
+		}
+		path = append(path, n)
+	}
+	if msg != nil && n.message != msg {
+		return
+	}
+	n.message = nil
+	// prune the branch that no longer holds messages
+	for i := len(levels); i > 0; i-- {
+		n := path[i]
+		if n.message != nil || len(n.children) != 0 {
+			break
+		}
+		delete(path[i-1].children, levels[i-1])
+	}
+}
+
+// match returns the unexpired retained messages whose topics match a topic filter, and removes the expired ones.
+// Topics starting with '$' are not matched by filters starting with a wildcard. [MQTT-4.7.2-1]
+func (rs *retainedStore) match(filter string) []*retainedMessage {
+	var messages, expired []*retainedMessage
+	now := time.Now()
+	rs.mu.RLock()
+	rs.root.match(strings.Split(filter, packet.TopicSeparator), 0, func(msg *retainedMessage) {
+		if expiresBefore(msg.expiresAt, now) {
+			expired = append(expired, msg)
+		} else {
+			messages = append(messages, msg)
+		}
+	})
+	rs.mu.RUnlock()
+	for _, msg := range expired {
+		rs.remove(msg.pm.TopicName, msg)
+	}
+	return messages
+}
+
+func (n *retainedNode) match(levels []string, depth int, fn func(*retainedMessage)) {
+	if depth == len(levels) {
+		if n.message != nil {
+			fn(n.message)
+		}
+		return
+	}
+	switch level := levels[depth]; level {
+	case packet.MultiLevelWildcard:
+		// '#' also matches the parent level [MQTT-4.7.1-2]
+		if n.message != nil && depth > 0 {
+			fn(n.message)
+		}
+		n.visit(depth, fn)
+	case packet.SingleLevelWildcard:
+		for name, child := range n.children {
+			if depth == 0 && strings.HasPrefix(name, "$") {
+				continue
+			}
+			child.match(levels, depth+1, fn)
+		}
+	default:
+		if child, ok := n.children[level]; ok {
+			child.match(levels, depth+1, fn)
+		}
+	}
+}
+
+// visit calls fn for every message below n.
+func (n *retainedNode) visit(depth int, fn func(*retainedMessage)) {
+	for name, child := range n.children {
+		if depth == 0 && strings.HasPrefix(name, "$") {
+			continue
+		}
+		if child.message != nil {
+			fn(child.message)
+		}
+		child.visit(depth+1, fn)
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+func retainedTopics(rs *retainedStore, filter string) string {
+	var topics []string
+	for _, msg := range rs.match(filter) {
+		topics = append(topics, msg.pm.TopicName)
+	}
+	sort.Strings(topics)
+	return strings.Join(topics, ",")
+}
+
+func TestRetainedStore(t *testing.T) {
+	rs := newRetainedStore()
+	for _, topic := range []string{"a", "a/b", "a/b/c", "a/c", "$SYS/uptime"} {
+		rs.retain(&packet.PublishMessage{TopicName: topic, Payload: []byte(topic)})
+	}
+	tests := []struct {
+		filter string
+		expect string
+	}{
+		{"a", "a"},
+		{"a/+", "a/b,a/c"},
+		{"a/#", "a,a/b,a/b/c,a/c"},
+		{"+/b", "a/b"},
+		{"#", "a,a/b,a/b/c,a/c"},
+		{"+/uptime", ""},
+		{"$SYS/#", "$SYS/uptime"},
+		{"b", ""},
+	}
+	for _, tt := range tests {
+		if got := retainedTopics(rs, tt.filter); got != tt.expect {
+			t.Errorf("%s: expected %q but got %q", tt.filter, tt.expect, got)
+		}
+	}
+
+	rs.retain(&packet.PublishMessage{TopicName: "a/b"})
+	if got := retainedTopics(rs, "a/+"); got != "a/c" {
+		t.Errorf("expected an empty payload to clear the topic but got %q", got)
+	}
+	rs.retain(&packet.PublishMessage{TopicName: "a/b/c"})
+	if _, ok := rs.root.children["a"].children["b"]; ok {
+		t.Errorf("expected the empty branch to be pruned")
+	}
+
+	rs.retain(&packet.PublishMessage{TopicName: "short", Payload: []byte("x"), Properties: packet.PublishMessageProperties{MessageExpiryInterval: 1}})
+	rs.root.children["short"].message.expiresAt = time.Now().Add(-time.Second)
+	if got := retainedTopics(rs, "short"); got != "" {
+		t.Errorf("expected the expired message to be skipped but got %q", got)
+	}
+	if _, ok := rs.root.children["short"]; ok {
+		t.Errorf("expected the expired message to be removed")
+	}
+}
+
+func TestRetainHandling(t *testing.T) {
+	s := startTestServer(t)
+	pub := dialTestConn(t, s, packet.ProtoVer5)
+	pub.connect(&packet.ConnectionRequest{ClientID: "pub"})
+	pub.write(&packet.PublishMessage{QoSLevel: packet.QoS1, PacketID: 1, Retain: true, TopicName: "status/door", Payload: []byte("open")})
+	pub.read()
+
+	sub := dialTestConn(t, s, packet.ProtoVer5)
+	sub.connect(&packet.ConnectionRequest{ClientID: "sub"})
+	sub.subscribe(&packet.SubscribePayload{TopicFilter: "status/+", QoS: packet.QoS1})
+	pm := sub.readPublish()
+	if !pm.Retain || pm.QoSLevel != packet.QoS1 || string(pm.Payload) != "open" {
+		t.Errorf("unexpected retained message %v", packet.JSON(pm))
+	}
+
+	sub.subscribe(&packet.SubscribePayload{TopicFilter: "status/+", RetainHandling: packet.RetainHandlingSendWhenNotExist})
+	sub.subscribe(&packet.SubscribePayload{TopicFilter: "status/#", RetainHandling: packet.RetainHandlingDoNotSend})
+	sub.subscribe(&packet.SubscribePayload{TopicFilter: "#", RetainHandling: packet.RetainHandlingSendWhenNotExist})
+	if pm := sub.readPublish(); pm.TopicName != "status/door" {
+		t.Errorf("unexpected retained message %v", packet.JSON(pm))
+	}
+	sub.write(&packet.PingRequest{})
+	if _, ok := sub.read().(*packet.PingResponse); !ok {
+		t.Errorf("expected only the new subscription to receive the retained message")
+	}
+}
+
+func TestRetainUnavailable(t *testing.T) {
+	s := startTestServer(t, WithRetainAvailable(false))
+	tc := dialTestConn(t, s, packet.ProtoVer5)
+	connack := tc.connect(&packet.ConnectionRequest{ClientID: "c"})
+	if !connack.Properties.RetainAvailable.Flag() || connack.Properties.RetainAvailable.Value() != 0 {
+		t.Fatalf("expected CONNACK to advertise retain unavailable")
+	}
+	tc.write(&packet.PublishMessage{Retain: true, TopicName: "t", Payload: []byte("x")})
+	if d, ok := tc.read().(*packet.Disconnect); !ok || d.ReasonCode != packet.RCRetainNotSupported {
+		t.Errorf("expected DISCONNECT with %v", packet.RCRetainNotSupported)
+	}
+}
@@ -1,8 +1,305 @@
 package server
 
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rwasayc/cactusmq/base"
+	"github.com/rwasayc/cactusmq/packet"
+)
+
 type Server struct {
+	opts options
+
+	mu        sync.Mutex
+	started   bool
+	closed    bool
+	endpoints []*endpoint
+
+	conns    *base.SyncMap[*client, struct{}] // every open connection
+	clients  *base.SyncMap[string, *client]   // connections that completed CONNECT, by client id
+	broker   *broker
+	retained *retainedStore
+	sessions SessionStore
+	wg       sync.WaitGroup
 }
 
 func NewServer(opts ...option) *Server {
-	return &Server{}
+	s := &Server{
+		opts:     defaultOptions(),
+		conns:    base.NewSyncMap[*client, struct{}](),
+		clients:  base.NewSyncMap[string, *client](),
+		retained: newRetainedStore(),
+	}
+	for _, opt := range opts {
+		opt.apply(&s.opts)
+	}
+	s.broker = newBroker(s.opts.shareStrategy, func(clientID string) bool {
+		_, ok := s.clients.Load(clientID)
+		return ok
+	})
+	s.sessions = s.opts.sessionStore
+	if s.sessions == nil {
+		s.sessions = NewMemorySessionStore()
+	}
+	return s
+}
+
+// Start opens the listeners and serves their connections in the background.
+// Without a listener, the server listens on the TCP address set by WithAddress.
+func (s *Server) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+	if s.started {
+		return ErrServerStarted
+	}
+	listeners := s.opts.listeners
+	if len(listeners) == 0 {
+		listeners = []Listener{&netListener{network: "tcp", address: s.opts.address}}
+	}
+	var endpoints []*endpoint
+	for _, l := range listeners {
+		config := l.Config()
+		err := config.validate()
+		var nl net.Listener
+		if err == nil {
+			nl, err = l.Listen()
+		}
+		if err != nil {
+			for _, e := range endpoints {
+				e.Close()
+			}
+			return err
+		}
+		endpoints = append(endpoints, &endpoint{Listener: nl, config: config})
+	}
+	s.started = true
+	s.endpoints = endpoints
+	for _, e := range endpoints {
+		s.wg.Add(1)
+		go s.acceptLoop(e)
+	}
+	return nil
+}
+
+// Addr returns the address of the first listener, or nil before Start.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.endpoints) == 0 {
+		return nil
+	}
+	return s.endpoints[0].Addr()
+}
+
+// Addrs returns the addresses of the listeners in the order they were set, or nil before Start.
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var addrs []net.Addr
+	for _, e := range s.endpoints {
+		addrs = append(addrs, e.Addr())
+	}
+	return addrs
+}
+
+// Shutdown stops accepting connections, disconnects every client with RCServerShuttingDown
+// and waits for the connections to close or ctx to be done.
+// Will messages waiting for their delay are published at once, and sessions no longer expire.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.closed = true
+	for _, e := range s.endpoints {
+		e.Close()
+	}
+	s.stopSessionTimers()
+	s.mu.Unlock()
+
+	s.conns.Range(func(c *client, _ struct{}) bool {
+		c.disconnect(packet.RCServerShuttingDown)
+		return true
+	})
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.conns.Range(func(c *client, _ struct{}) bool {
+			c.conn.Close()
+			return true
+		})
+		return ctx.Err()
+	}
+}
+
+// stopSessionTimers stops the expiry of the sessions and publishes the will messages waiting for their delay,
+// so that no timer fires once the server is closed. The caller holds s.mu.
+func (s *Server) stopSessionTimers() {
+	var sessions []*Session
+	s.sessions.Range(func(sess *Session) bool {
+		sessions = append(sessions, sess)
+		return true
+	})
+	for _, sess := range sessions {
+		if sess.timer != nil {
+			sess.timer.Stop()
+			sess.timer = nil
+		}
+		s.publishWill(sess)
+	}
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *Server) acceptLoop(e *endpoint) {
+	defer s.wg.Done()
+	var delay time.Duration
+	for {
+		conn, err := e.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// back off on temporary errors such as running out of file descriptors
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > time.Second {
+				delay = time.Second
+			}
+			log.Printf("accept error: %v; retrying in %v", err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		s.serveConn(conn, e)
+	}
+}
+
+// serveConn runs a connection accepted by a listener in the background until it is closed.
+func (s *Server) serveConn(conn net.Conn, e *endpoint) {
+	s.mu.Lock()
+	if s.closed || !e.acquire() {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	c := newClient(s, conn, e)
+	s.conns.Store(c, struct{}{})
+	go func() {
+		defer s.wg.Done()
+		defer e.release()
+		defer s.conns.Delete(c)
+		c.serve()
+	}()
+}
+
+// register makes c the connection of its client id, taking over any existing connection, and opens its session.
+// It returns whether an existing session was resumed. [MQTT-3.1.4-3]
+func (s *Server) register(c *client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		old, ok := s.clients.Load(c.id)
+		if !ok || old == c {
+			break
+		}
+		old.disconnect(packet.RCSessionTakenOver)
+		s.mu.Unlock()
+		<-old.done
+		s.mu.Lock()
+	}
+	s.clients.Store(c.id, c)
+	sess, present := s.openSession(c)
+	c.session = sess
+	return present
+}
+
+// unregister removes c if it is still the connection of its client id and closes its session.
+func (s *Server) unregister(c *client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cur, ok := s.clients.Load(c.id); ok && cur == c {
+		s.clients.Delete(c.id)
+	}
+	s.closeSession(c)
+}
+
+// checkConnect decides the CONNACK reason code of a CONNECT packet.
+func (s *Server) checkConnect(cr *packet.ConnectionRequest) packet.RCode {
+	if rcode := cr.Validate(); rcode != packet.RCSuccess {
+		return rcode
+	}
+	if s.isClosed() {
+		return packet.RCServerUnavailable
+	}
+	if cr.ClientID == "" && cr.ProtocolVersion != packet.ProtoVer5 && !cr.CleanStart.Value() { // [MQTT-3.1.3-8]
+		return packet.RClientIDNotValid
+	}
+	if cr.Will.Value().Retain && !s.opts.retainAvailable {
+		return packet.RCRetainNotSupported
+	}
+	if cr.Will.Value().Qos.Value() > s.opts.maximumQoS { // [MQTT-3.2.2-12]
+		return packet.RCQoSNotSupported
+	}
+	return packet.RCSuccess
+}
+
+// connackProperties returns the CONNACK properties the server advertises to v5 clients.
+func (s *Server) connackProperties() *packet.ConnectAcknowledgementProperties {
+	props := &packet.ConnectAcknowledgementProperties{
+		TopicAliasMaximum: s.opts.topicAliasMaximum,
+		MaximumPacketSize: s.opts.maximumPacketSize,
+	}
+	if s.opts.receiveMaximum < math.MaxUint16 {
+		props.ReceiveMaximum = s.opts.receiveMaximum
+	}
+	if s.opts.maximumQoS < packet.QoS2 {
+		props.MaximumQoS = packet.NewFlagV(s.opts.maximumQoS)
+	}
+	if !s.opts.retainAvailable {
+		props.RetainAvailable = packet.NewFlagV[uint8](0)
+	}
+	if !s.opts.wildcardSubAvailable {
+		props.WildcardSubscriptionAvailable = packet.NewFlagV[uint8](0)
+	}
+	if !s.opts.sharedSubAvailable {
+		props.SharedSubscriptionAvailable = packet.NewFlagV[uint8](0)
+	}
+	if !s.opts.subscriptionIDAvailable {
+		props.SubscriptionIdentifierAvailable = packet.NewFlagV[uint8](0)
+	}
+	return props
+}
+
+// assignClientID creates a unique client id for a client that connected without one. [MQTT-3.1.3-6]
+func (s *Server) assignClientID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "cactusmq-" + hex.EncodeToString(b)
 }
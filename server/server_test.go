@@ -0,0 +1,307 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+// testConn is a raw MQTT connection used to drive the server in tests.
+type testConn struct {
+	t      *testing.T
+	conn   net.Conn
+	ver    packet.ProtocolVersion
+	reader *packet.Reader
+	writer *packet.Writer
+}
+
+func startTestServer(t *testing.T, opts ...option) *Server {
+	t.Helper()
+	s := NewServer(append([]option{WithAddress("127.0.0.1:0")}, opts...)...)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(func() {
+		s.Shutdown(context.Background())
+	})
+	return s
+}
+
+func dialTestConn(t *testing.T, s *Server, ver packet.ProtocolVersion) *testConn {
+	t.Helper()
+	return dialTestAddr(t, s.Addr(), ver)
+}
+
+func dialTestAddr(t *testing.T, addr net.Addr, ver packet.ProtocolVersion) *testConn {
+	t.Helper()
+	conn, err := net.Dial(addr.Network(), addr.String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return newTestConn(t, conn, ver)
+}
+
+func newTestConn(t *testing.T, conn net.Conn, ver packet.ProtocolVersion) *testConn {
+	tc := &testConn{t: t, conn: conn, ver: ver, reader: packet.NewReader(conn, 0), writer: packet.NewWriter(conn)}
+	tc.reader.SetProtocolVersion(ver)
+	return tc
+}
+
+func (tc *testConn) write(p packet.Packet) {
+	tc.t.Helper()
+	if err := tc.writer.WritePacket(tc.ver, p); err != nil {
+		tc.t.Fatalf("failed to write %v: %v", p.Type(), err)
+	}
+}
+
+func (tc *testConn) read() packet.Packet {
+	tc.t.Helper()
+	tc.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, p, err := tc.reader.ReadPacket()
+	if err != nil {
+		tc.t.Fatalf("failed to read: %v", err)
+	}
+	return p
+}
+
+// connect sends a CONNECT and returns the CONNACK.
+func (tc *testConn) connect(cr *packet.ConnectionRequest) *packet.ConnectAcknowledgement {
+	tc.t.Helper()
+	cr.ProtocolVersion = tc.ver
+	cr.ProtocolName = map[packet.ProtocolVersion]packet.ProtocolName{
+		packet.ProtoVer31:  packet.FixedProtocolNameV31,
+		packet.ProtoVer311: packet.FixedProtocolNameV311,
+		packet.ProtoVer5:   packet.FixedProtocolNameV5,
+	}[tc.ver]
+	tc.write(cr)
+	connack, ok := tc.read().(*packet.ConnectAcknowledgement)
+	if !ok {
+		tc.t.Fatalf("expected CONNACK")
+	}
+	return connack
+}
+
+func (tc *testConn) expectClosed() {
+	tc.t.Helper()
+	tc.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, p, err := tc.reader.ReadPacket(); err == nil {
+		tc.t.Fatalf("expected connection to be closed but got %v", p.Type())
+	}
+}
+
+func TestServerConnect(t *testing.T) {
+	s := startTestServer(t, WithServerKeepAlive(30))
+
+	t.Run("v5", func(t *testing.T) {
+		tc := dialTestConn(t, s, packet.ProtoVer5)
+		connack := tc.connect(&packet.ConnectionRequest{ClientID: "c1", Keepalive: 60})
+		if connack.ConnectReasonCode != packet.RCSuccess {
+			t.Fatalf("expected %v but got %v", packet.RCSuccess, connack.ConnectReasonCode)
+		}
+		if connack.Properties.ServerKeepAlive != 30 {
+			t.Errorf("expected server keep alive 30 but got %d", connack.Properties.ServerKeepAlive)
+		}
+		tc.write(&packet.PingRequest{})
+		if _, ok := tc.read().(*packet.PingResponse); !ok {
+			t.Errorf("expected PINGRESP")
+		}
+		tc.write(&packet.Disconnect{})
+		tc.expectClosed()
+	})
+
+	t.Run("v5 assigned client id", func(t *testing.T) {
+		tc := dialTestConn(t, s, packet.ProtoVer5)
+		connack := tc.connect(&packet.ConnectionRequest{CleanStart: packet.NewFlagV(true)})
+		if connack.ConnectReasonCode != packet.RCSuccess || connack.Properties.AssignedClientIdentifier == "" {
+			t.Errorf("expected an assigned client id but got %v", packet.JSON(connack))
+		}
+	})
+
+	t.Run("v3.1.1 empty client id without clean session", func(t *testing.T) {
+		tc := dialTestConn(t, s, packet.ProtoVer311)
+		connack := tc.connect(&packet.ConnectionRequest{})
+		if connack.ConnectReasonCode != packet.RClientIDNotValid {
+			t.Errorf("expected %v but got %v", packet.RClientIDNotValid, connack.ConnectReasonCode)
+		}
+		tc.expectClosed()
+	})
+
+	t.Run("unsupported protocol", func(t *testing.T) {
+		tc := dialTestConn(t, s, packet.ProtoVer311)
+		tc.write(&packet.ConnectionRequest{ProtocolName: packet.FixedProtocolNameV5, ProtocolVersion: 6, ClientID: "c2"})
+		connack, ok := tc.read().(*packet.ConnectAcknowledgement)
+		if !ok || connack.ConnectReasonCode != packet.RCUnsupportedProtocol {
+			t.Errorf("expected CONNACK with %v", packet.RCUnsupportedProtocol)
+		}
+		tc.expectClosed()
+	})
+
+	t.Run("first packet is not CONNECT", func(t *testing.T) {
+		tc := dialTestConn(t, s, packet.ProtoVer5)
+		tc.write(&packet.PingRequest{})
+		tc.expectClosed()
+	})
+
+	t.Run("second CONNECT", func(t *testing.T) {
+		tc := dialTestConn(t, s, packet.ProtoVer5)
+		tc.connect(&packet.ConnectionRequest{ClientID: "c3"})
+		tc.write(&packet.ConnectionRequest{ProtocolName: packet.FixedProtocolNameV5, ProtocolVersion: packet.ProtoVer5, ClientID: "c3"})
+		d, ok := tc.read().(*packet.Disconnect)
+		if !ok || d.ReasonCode != packet.RCProtocolError {
+			t.Errorf("expected DISCONNECT with %v", packet.RCProtocolError)
+		}
+		tc.expectClosed()
+	})
+}
+
+func TestServerProtocolV3(t *testing.T) {
+	s := startTestServer(t)
+
+	t.Run("v3.1.1", func(t *testing.T) {
+		tc := dialTestConn(t, s, packet.ProtoVer311)
+		if connack := tc.connect(&packet.ConnectionRequest{ClientID: "device", CleanStart: packet.NewFlagV(true)}); connack.ConnectReasonCode != packet.RCSuccess {
+			t.Fatalf("expected %v but got %v", packet.RCSuccess, connack.ConnectReasonCode)
+		}
+		codes := tc.subscribe(
+			&packet.SubscribePayload{TopicFilter: "dev/+", QoS: packet.QoS1},
+			&packet.SubscribePayload{TopicFilter: "dev/#/bad"},
+		)
+		if len(codes) != 2 || codes[0] != packet.RCGrantedQoS1 || codes[1] != packet.RCUnspecifiedError {
+			t.Fatalf("expected return codes 0x01 and 0x80 but got %v", codes)
+		}
+		// the message is forwarded to the subscription of the publisher before it is acknowledged
+		tc.write(&packet.PublishMessage{QoSLevel: packet.QoS1, PacketID: 5, TopicName: "dev/1", Payload: []byte("on")})
+		pm := tc.readPublish()
+		if pm.TopicName != "dev/1" || string(pm.Payload) != "on" {
+			t.Errorf("unexpected message %v", packet.JSON(pm))
+		}
+		if ack, ok := tc.read().(*packet.PublishAcknowledgement); !ok || ack.PacketID != 5 {
+			t.Fatalf("expected PUBACK for packet 5")
+		}
+		tc.write(&packet.PublishAcknowledgement{PacketID: pm.PacketID})
+		tc.write(&packet.UnsubscribeRequest{PacketID: 2, TopicFilters: []string{"dev/+"}})
+		if _, ok := tc.read().(*packet.UnsubscribeAcknowledgement); !ok {
+			t.Errorf("expected UNSUBACK")
+		}
+		tc.write(&packet.Disconnect{})
+		tc.expectClosed()
+	})
+
+	t.Run("v3.1 client id too long", func(t *testing.T) {
+		tc := dialTestConn(t, s, packet.ProtoVer31)
+		connack := tc.connect(&packet.ConnectionRequest{ClientID: "a-client-id-of-24-chars!", CleanStart: packet.NewFlagV(true)})
+		if connack.ConnectReasonCode != packet.RClientIDNotValid {
+			t.Errorf("expected %v but got %v", packet.RClientIDNotValid, connack.ConnectReasonCode)
+		}
+		tc.expectClosed()
+	})
+}
+
+func TestServerSessionTakenOver(t *testing.T) {
+	s := startTestServer(t)
+	first := dialTestConn(t, s, packet.ProtoVer5)
+	first.connect(&packet.ConnectionRequest{ClientID: "same"})
+	second := dialTestConn(t, s, packet.ProtoVer5)
+	if connack := second.connect(&packet.ConnectionRequest{ClientID: "same"}); connack.ConnectReasonCode != packet.RCSuccess {
+		t.Fatalf("expected %v but got %v", packet.RCSuccess, connack.ConnectReasonCode)
+	}
+	d, ok := first.read().(*packet.Disconnect)
+	if !ok || d.ReasonCode != packet.RCSessionTakenOver {
+		t.Errorf("expected DISCONNECT with %v", packet.RCSessionTakenOver)
+	}
+	first.expectClosed()
+}
+
+func TestServerCapabilities(t *testing.T) {
+	s := startTestServer(t, WithMaximumQoS(packet.QoS1), WithWildcardSubscriptionAvailable(false), WithSharedSubscriptionAvailable(false))
+	tc := dialTestConn(t, s, packet.ProtoVer5)
+	connack := tc.connect(&packet.ConnectionRequest{ClientID: "limited"})
+	if connack.Properties.MaximumQoS.Value() != packet.QoS1 || connack.Properties.WildcardSubscriptionAvailable.Value() != 0 ||
+		!connack.Properties.SharedSubscriptionAvailable.Flag() {
+		t.Errorf("unexpected CONNACK properties %v", packet.JSON(connack.Properties))
+	}
+
+	rcodes := tc.subscribe(
+		&packet.SubscribePayload{TopicFilter: "a", QoS: packet.QoS2},
+		&packet.SubscribePayload{TopicFilter: "a/+"},
+		&packet.SubscribePayload{TopicFilter: "$share/g/a"},
+	)
+	expected := []packet.RCode{packet.GrantedQoS(packet.QoS1), packet.RCWildcardSubscriptionsNotSupported, packet.RCSharedSubscriptionsNotSupported}
+	if packet.JSON(rcodes) != packet.JSON(expected) {
+		t.Errorf("expected %v but got %v", expected, rcodes)
+	}
+
+	tc.write(&packet.PublishMessage{TopicName: "a", QoSLevel: packet.QoS2, PacketID: 1})
+	if d, ok := tc.read().(*packet.Disconnect); !ok || d.ReasonCode != packet.RCQoSNotSupported {
+		t.Errorf("expected DISCONNECT with %v", packet.RCQoSNotSupported)
+	}
+	tc.expectClosed()
+
+	will := dialTestConn(t, s, packet.ProtoVer5)
+	connack = will.connect(&packet.ConnectionRequest{ClientID: "will", Will: packet.NewFlagV(packet.ConnectWill{Topic: "w", Qos: packet.NewFlagV(packet.QoS2)})})
+	if connack.ConnectReasonCode != packet.RCQoSNotSupported {
+		t.Errorf("expected %v for a will with QoS 2 but got %v", packet.RCQoSNotSupported, connack.ConnectReasonCode)
+	}
+}
+
+func TestServerKeepAliveTimeout(t *testing.T) {
+	s := startTestServer(t)
+	tc := dialTestConn(t, s, packet.ProtoVer5)
+	tc.connect(&packet.ConnectionRequest{ClientID: "idle", Keepalive: 1})
+	tc.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, p, err := tc.reader.ReadPacket()
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if d, ok := p.(*packet.Disconnect); !ok || d.ReasonCode != packet.RCKeepAliveTimeout {
+		t.Errorf("expected DISCONNECT with %v", packet.RCKeepAliveTimeout)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	s := NewServer(WithAddress("127.0.0.1:0"))
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	v5 := dialTestConn(t, s, packet.ProtoVer5)
+	v5.connect(&packet.ConnectionRequest{ClientID: "v5"})
+	v311 := dialTestConn(t, s, packet.ProtoVer311)
+	v311.connect(&packet.ConnectionRequest{ClientID: "v311"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("failed to shutdown: %v", err)
+	}
+	d, ok := v5.read().(*packet.Disconnect)
+	if !ok || d.ReasonCode != packet.RCServerShuttingDown {
+		t.Errorf("expected DISCONNECT with %v", packet.RCServerShuttingDown)
+	}
+	v311.expectClosed()
+	if err := s.Start(); err != ErrServerClosed {
+		t.Errorf("expected %v but got %v", ErrServerClosed, err)
+	}
+}
+
+func TestClientCloseBlockedWrite(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	c := newClient(NewServer(), conn, nil)
+	go c.writeLoop()
+
+	// the peer never reads, so the write loop blocks before it can see the connection closing
+	c.outbound <- &packet.PingResponse{}
+	time.Sleep(10 * time.Millisecond)
+	c.disconnect(packet.RCKeepAliveTimeout)
+	select {
+	case <-c.writerDone:
+	case <-time.After(flushTimeout + time.Second):
+		t.Fatalf("expected the blocked write to end once the connection is closing")
+	}
+}
@@ -0,0 +1,500 @@
+package server
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rwasayc/cactusmq/base"
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+// NeverExpire is the session expiry interval of a session that is kept until the client starts a clean one. [MQTT-3.1.2-23]
+const NeverExpire = math.MaxUint32
+
+// Session is the state of a client that lasts across network connections. [MQTT-4.1.0-1]
+type Session struct {
+	ClientID string
+
+	mu             sync.Mutex
+	expiryInterval uint32                              // seconds the session is kept after the connection closes
+	subscriptions  map[string]*packet.SubscribePayload // by topic filter
+	inflight       map[uint16]*inflightMessage         // QoS 1 and 2 messages sent to the client and not completely acknowledged
+	received       map[uint16]struct{}                 // QoS 2 packet ids received from the client and not yet released
+	queue          []*message                          // QoS 1 and 2 messages waiting to be sent
+	resend         []*inflightMessage                  // in-flight messages waiting to be sent again on the attached connection
+	lastPacketID   uint16
+	sequence       uint64
+	client         *client                // the connection the session is attached to, nil when disconnected
+	timer          *time.Timer            // expires the session once disconnected, guarded by the server
+	will           *packet.PublishMessage // will message waiting for its delay, guarded by the server
+	willTimer      *time.Timer            // publishes the will message, guarded by the server
+}
+
+func newSession(clientID string, expiryInterval uint32) *Session {
+	return &Session{
+		ClientID:       clientID,
+		expiryInterval: expiryInterval,
+		subscriptions:  map[string]*packet.SubscribePayload{},
+		inflight:       map[uint16]*inflightMessage{},
+		received:       map[uint16]struct{}{},
+	}
+}
+
+// ExpiryInterval returns the seconds the session is kept after the network connection closes.
+func (s *Session) ExpiryInterval() uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.expiryInterval
+}
+
+func (s *Session) setExpiryInterval(interval uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expiryInterval = interval
+}
+
+// Subscriptions returns the subscriptions of the session.
+func (s *Session) Subscriptions() []*packet.SubscribePayload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subs := make([]*packet.SubscribePayload, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+func (s *Session) subscribe(sub *packet.SubscribePayload) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscriptions[sub.TopicFilter] = sub
+}
+
+func (s *Session) unsubscribe(filter string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscriptions, filter)
+}
+
+// message is an application message on its way to the client of a session.
+type message struct {
+	pm        *packet.PublishMessage // as published
+	publisher string
+	delivery  *delivery
+	out       *packet.PublishMessage // as sent to the client
+	expiresAt time.Time              // zero if the message does not expire
+}
+
+// expiryTime returns when a message published at now expires, or the zero time if it does not.
+func expiryTime(pm *packet.PublishMessage, now time.Time) time.Time {
+	if expiry := pm.Properties.MessageExpiryInterval; expiry != 0 {
+		return now.Add(time.Duration(expiry) * time.Second)
+	}
+	return time.Time{}
+}
+
+// expiresBefore reports whether a message expiring at expiresAt has expired at now.
+func expiresBefore(expiresAt, now time.Time) bool {
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+// stamp sets the remaining Message Expiry Interval of the PUBLISH sent to the client. [MQTT-3.3.2-6]
+// It returns false if the message has expired and must not be sent. [MQTT-3.3.2-5]
+func (m *message) stamp(now time.Time) bool {
+	if m.expiresAt.IsZero() {
+		return true
+	}
+	if expiresBefore(m.expiresAt, now) {
+		return false
+	}
+	// rounded up, as 0 would mean the message does not expire
+	m.out.Properties.MessageExpiryInterval = uint32((m.expiresAt.Sub(now) + time.Second - 1) / time.Second)
+	return true
+}
+
+// inflightMessage is an outbound QoS 1 or 2 message waiting for its acknowledgements.
+type inflightMessage struct {
+	*message
+	sequence uint64 // orders retransmissions as the messages were first sent [MQTT-4.6.0-1]
+	released bool   // PUBREC was received and PUBREL sent
+}
+
+// deliver forwards a message to the attached connection, or queues it while it cannot be sent.
+// QoS 0 messages are not queued. It returns false if the message was dropped.
+func (s *Session) deliver(msg *message, maxQueued int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msg.out = outgoing(msg.pm, msg.delivery)
+	if msg.out.QoSLevel == packet.QoS0 {
+		return s.client != nil && msg.stamp(time.Now()) && s.client.fits(msg.out) && s.client.trySend(msg.out)
+	}
+	s.drain()
+	if len(s.resend) == 0 && len(s.queue) == 0 && s.transmit(msg) {
+		return true
+	}
+	if len(s.queue) >= maxQueued {
+		s.purge()
+	}
+	if len(s.queue) >= maxQueued {
+		return false
+	}
+	s.queue = append(s.queue, msg)
+	return true
+}
+
+// transmit sends a QoS 1 or 2 message with a new packet id and tracks it until it is acknowledged.
+// It returns false if the client is disconnected, has Receive Maximum messages in flight, [MQTT-3.3.4-7]
+// all packet ids are in use or the connection is congested. A message larger than the Maximum Packet Size
+// of the client is discarded as if it was sent, [MQTT-3.1.2-25] and so is an expired message.
+func (s *Session) transmit(msg *message) bool {
+	if s.client == nil || len(s.inflight) >= int(s.client.receiveMaximum) {
+		return false
+	}
+	if !msg.stamp(time.Now()) || !s.client.fits(msg.out) {
+		return true
+	}
+	id := s.nextPacketID()
+	if id == 0 {
+		return false
+	}
+	msg.out.PacketID = id
+	if !s.send(msg.out) {
+		return false
+	}
+	s.sequence++
+	s.inflight[id] = &inflightMessage{message: msg, sequence: s.sequence}
+	return true
+}
+
+// drain sends the in-flight messages waiting to be sent again, then the queued messages, until one cannot be sent.
+func (s *Session) drain() {
+	for len(s.resend) > 0 {
+		m := s.resend[0]
+		if s.inflight[m.out.PacketID] == m {
+			var p packet.Packet = m.out
+			if m.released {
+				p = &packet.PublishRelease{PacketID: m.out.PacketID}
+			}
+			if s.client == nil || !s.send(p) {
+				return
+			}
+		}
+		s.resend[0] = nil
+		s.resend = s.resend[1:]
+	}
+	for len(s.queue) > 0 && s.transmit(s.queue[0]) {
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+	}
+}
+
+// send queues a packet for the attached connection without waiting. If the outbound channel is full,
+// the connection is marked congested so that its write loop drains the session once there is room.
+func (s *Session) send(p packet.Packet) bool {
+	if s.client.trySend(p) {
+		return true
+	}
+	s.client.congested.Store(true)
+	// the write loop may have made room before the mark
+	return s.client.trySend(p)
+}
+
+// resume sends the messages left by drain when the congested connection c has room again.
+func (s *Session) resume(c *client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == c {
+		s.drain()
+	}
+}
+
+// purge discards the queued messages that have expired. [MQTT-3.3.2-5]
+func (s *Session) purge() {
+	now := time.Now()
+	queue := s.queue[:0]
+	for _, msg := range s.queue {
+		if !expiresBefore(msg.expiresAt, now) {
+			queue = append(queue, msg)
+		}
+	}
+	clear(s.queue[len(queue):])
+	s.queue = queue
+}
+
+// nextPacketID allocates a packet id not used by an in-flight message, or returns 0 if there is none. [MQTT-2.2.1-4]
+func (s *Session) nextPacketID() uint16 {
+	for i := 0; i < math.MaxUint16; i++ {
+		s.lastPacketID++
+		if s.lastPacketID == 0 {
+			s.lastPacketID = 1
+		}
+		if _, ok := s.inflight[s.lastPacketID]; !ok {
+			return s.lastPacketID
+		}
+	}
+	return 0
+}
+
+// attach makes c the connection of the session. It resends the unacknowledged messages with DUP set,
+// in the order they were first sent, then the queued ones. [MQTT-4.4.0-1]
+// It does not wait for the connection: the messages that do not fit in its outbound channel are sent by drain.
+func (s *Session) attach(c *client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.client = c
+	resend := make([]*inflightMessage, 0, len(s.inflight))
+	for _, m := range s.inflight {
+		resend = append(resend, m)
+	}
+	sortInflight(resend)
+	s.resend = resend[:0]
+	for _, m := range resend {
+		if !m.released && !c.fits(m.out) {
+			delete(s.inflight, m.out.PacketID)
+			continue
+		}
+		if !m.released {
+			dup := *m.out
+			dup.DUP = true // [MQTT-3.3.1-1]
+			m.out = &dup
+		}
+		s.resend = append(s.resend, m)
+	}
+	s.drain()
+}
+
+// detach removes c from the session if it is still attached. The messages of shared subscriptions the client
+// has not received yet are taken out of the session and returned, to be sent to another member.
+func (s *Session) detach(c *client) []*message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != c {
+		return nil
+	}
+	s.client = nil
+	clear(s.resend)
+	s.resend = nil
+
+	var pending []*inflightMessage
+	for id, m := range s.inflight {
+		if m.delivery.share != nil && !m.released {
+			pending = append(pending, m)
+			delete(s.inflight, id)
+		}
+	}
+	sortInflight(pending)
+	shared := make([]*message, 0, len(pending))
+	for _, m := range pending {
+		shared = append(shared, m.message)
+	}
+	queue := s.queue[:0]
+	for _, msg := range s.queue {
+		if msg.delivery.share != nil {
+			shared = append(shared, msg)
+		} else {
+			queue = append(queue, msg)
+		}
+	}
+	clear(s.queue[len(queue):])
+	s.queue = queue
+	return shared
+}
+
+// sortInflight orders in-flight messages as they were first sent.
+func sortInflight(messages []*inflightMessage) {
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].sequence < messages[j].sequence
+	})
+}
+
+// puback completes a QoS 1 delivery. It returns false if no QoS 1 message is in flight with the id.
+func (s *Session) puback(id uint16) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.inflight[id]
+	if !ok || m.out.QoSLevel != packet.QoS1 {
+		return false
+	}
+	delete(s.inflight, id)
+	s.drain()
+	return true
+}
+
+// pubrec records the PUBREC of a QoS 2 delivery. A failure reason code ends the delivery. [MQTT-4.3.3-4]
+// It returns false if no QoS 2 message waits for a PUBREC with the id.
+func (s *Session) pubrec(id uint16, rcode packet.RCode) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.inflight[id]
+	if !ok || m.out.QoSLevel != packet.QoS2 {
+		return false
+	}
+	if rcode >= 0x80 {
+		delete(s.inflight, id)
+		s.drain()
+		return true
+	}
+	m.released = true
+	return true
+}
+
+// pubcomp completes a QoS 2 delivery. It returns false if no PUBREL was sent with the id.
+func (s *Session) pubcomp(id uint16) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.inflight[id]
+	if !ok || !m.released {
+		return false
+	}
+	delete(s.inflight, id)
+	s.drain()
+	return true
+}
+
+// receive records the packet id of a QoS 2 message from the client. It returns RCPacketIDInUse if the id
+// was already received and not released, in which case the message must not be delivered again, [MQTT-4.3.3-10]
+// and RCReceiveMaximumExceeded if maximum messages are already waiting for their PUBREL.
+func (s *Session) receive(id uint16, maximum uint16) packet.RCode {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.received[id]; ok {
+		return packet.RCPacketIDInUse
+	}
+	if len(s.received) >= int(maximum) {
+		return packet.RCReceiveMaximumExceeded
+	}
+	s.received[id] = struct{}{}
+	return packet.RCSuccess
+}
+
+// release forgets the packet id of a received QoS 2 message. It returns false if the id is unknown.
+func (s *Session) release(id uint16) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.received[id]; !ok {
+		return false
+	}
+	delete(s.received, id)
+	return true
+}
+
+// SessionStore keeps the sessions of clients by client id.
+type SessionStore interface {
+	Load(clientID string) (*Session, bool)
+	Store(session *Session)
+	Delete(clientID string)
+	Range(fn func(session *Session) bool)
+}
+
+// NewMemorySessionStore creates a SessionStore that keeps sessions in memory.
+func NewMemorySessionStore() SessionStore {
+	return &memorySessionStore{sessions: base.NewSyncMap[string, *Session]()}
+}
+
+type memorySessionStore struct {
+	sessions *base.SyncMap[string, *Session]
+}
+
+func (m *memorySessionStore) Load(clientID string) (*Session, bool) {
+	return m.sessions.Load(clientID)
+}
+
+func (m *memorySessionStore) Store(session *Session) {
+	m.sessions.Store(session.ClientID, session)
+}
+
+func (m *memorySessionStore) Delete(clientID string) {
+	m.sessions.Delete(clientID)
+}
+
+func (m *memorySessionStore) Range(fn func(session *Session) bool) {
+	m.sessions.Range(func(_ string, session *Session) bool {
+		return fn(session)
+	})
+}
+
+// sessionExpiryInterval returns the session expiry interval requested by a CONNECT.
+// Before v5 a session without Clean Session lasts until the client cleans it. [MQTT-3.1.2-4]
+func sessionExpiryInterval(cr *packet.ConnectionRequest) uint32 {
+	if cr.ProtocolVersion != packet.ProtoVer5 {
+		if cr.CleanStart.Value() {
+			return 0
+		}
+		return NeverExpire
+	}
+	if cr.Properties == nil {
+		return 0
+	}
+	return cr.Properties.SessionExpiryInterval.Value()
+}
+
+// openSession resumes the session of c, or starts a new one on Clean Start or when none exists.
+// It returns whether an existing session was resumed. The caller holds s.mu. [MQTT-3.1.2-4] [MQTT-3.1.2-5]
+func (s *Server) openSession(c *client) (*Session, bool) {
+	expiry := sessionExpiryInterval(c.connect)
+	sess, ok := s.sessions.Load(c.id)
+	if ok && sess.timer != nil {
+		sess.timer.Stop()
+		sess.timer = nil
+	}
+	if ok && !c.connect.CleanStart.Value() {
+		s.cancelWill(sess)
+		sess.setExpiryInterval(expiry)
+		return sess, true
+	}
+	if ok {
+		s.endSession(sess)
+	}
+	sess = newSession(c.id, expiry)
+	s.sessions.Store(sess)
+	return sess, false
+}
+
+// closeSession ends the session of c when its expiry interval is 0, or schedules its expiry.
+// Sessions closed after the server are kept without expiry. The caller holds s.mu. [MQTT-4.1.0-2]
+func (s *Server) closeSession(c *client) {
+	sess := c.session
+	if cur, ok := s.sessions.Load(sess.ClientID); !ok || cur != sess {
+		return
+	}
+	if c.will != nil {
+		s.scheduleWill(sess, c.will, willDelay(c.connect))
+	}
+	switch expiry := sess.ExpiryInterval(); expiry {
+	case 0:
+		s.endSession(sess)
+	case NeverExpire:
+	default:
+		if s.closed {
+			return
+		}
+		sess.timer = time.AfterFunc(time.Duration(expiry)*time.Second, func() {
+			s.expireSession(sess)
+		})
+	}
+}
+
+// expireSession ends a session whose expiry interval elapsed unless a client resumed it in the meantime.
+func (s *Server) expireSession(sess *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cur, ok := s.sessions.Load(sess.ClientID); s.closed || !ok || cur != sess {
+		return
+	}
+	if _, connected := s.clients.Load(sess.ClientID); connected {
+		return
+	}
+	s.endSession(sess)
+}
+
+// endSession discards a session and its subscriptions, publishing a will message still waiting for its delay.
+// The caller holds s.mu.
+func (s *Server) endSession(sess *Session) {
+	s.publishWill(sess)
+	for _, sub := range sess.Subscriptions() {
+		s.broker.unsubscribe(sess.ClientID, sub.TopicFilter)
+	}
+	s.sessions.Delete(sess.ClientID)
+}
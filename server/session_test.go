@@ -0,0 +1,350 @@
+package server
+
+import (
+	"math"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+func TestSessionExpiryInterval(t *testing.T) {
+	tests := []struct {
+		name   string
+		cr     *packet.ConnectionRequest
+		expect uint32
+	}{
+		{"v3.1.1 clean session", &packet.ConnectionRequest{ProtocolVersion: packet.ProtoVer311, CleanStart: packet.NewFlagV(true)}, 0},
+		{"v3.1.1 persistent session", &packet.ConnectionRequest{ProtocolVersion: packet.ProtoVer311}, NeverExpire},
+		{"v5 without properties", &packet.ConnectionRequest{ProtocolVersion: packet.ProtoVer5}, 0},
+		{"v5 with expiry", &packet.ConnectionRequest{
+			ProtocolVersion: packet.ProtoVer5,
+			Properties:      &packet.ConnectProperties{SessionExpiryInterval: packet.NewFlagV[uint32](60)},
+		}, 60},
+	}
+	for _, tt := range tests {
+		if got := sessionExpiryInterval(tt.cr); got != tt.expect {
+			t.Errorf("%s: expected %d but got %d", tt.name, tt.expect, got)
+		}
+	}
+}
+
+func persistentConnect(clientID string, expiry uint32) *packet.ConnectionRequest {
+	return &packet.ConnectionRequest{
+		ClientID:   clientID,
+		Properties: &packet.ConnectProperties{SessionExpiryInterval: packet.NewFlagV(expiry)},
+	}
+}
+
+func TestSessionResume(t *testing.T) {
+	s := startTestServer(t)
+	tc := dialTestConn(t, s, packet.ProtoVer5)
+	if connack := tc.connect(persistentConnect("durable", 60)); connack.SessionPresent {
+		t.Fatalf("expected no session present on first connect")
+	}
+	tc.subscribe(&packet.SubscribePayload{TopicFilter: "jobs/#", QoS: packet.QoS1})
+	tc.write(&packet.Disconnect{})
+	tc.expectClosed()
+
+	pub := dialTestConn(t, s, packet.ProtoVer5)
+	pub.connect(&packet.ConnectionRequest{ClientID: "producer"})
+	pub.write(&packet.PublishMessage{QoSLevel: packet.QoS1, PacketID: 1, TopicName: "jobs/1", Payload: []byte("queued")})
+	if ack, ok := pub.read().(*packet.PublishAcknowledgement); !ok || ack.ReasonCode != packet.RCSuccess {
+		t.Fatalf("expected PUBACK with %v", packet.RCSuccess)
+	}
+
+	tc = dialTestConn(t, s, packet.ProtoVer5)
+	if connack := tc.connect(persistentConnect("durable", 60)); !connack.SessionPresent {
+		t.Fatalf("expected session present on reconnect")
+	}
+	if pm := tc.readPublish(); pm.TopicName != "jobs/1" || string(pm.Payload) != "queued" {
+		t.Errorf("unexpected queued message %v", packet.JSON(pm))
+	}
+
+	tc.write(&packet.Disconnect{})
+	tc.expectClosed()
+	tc = dialTestConn(t, s, packet.ProtoVer5)
+	cr := persistentConnect("durable", 60)
+	cr.CleanStart = packet.NewFlagV(true)
+	if connack := tc.connect(cr); connack.SessionPresent {
+		t.Errorf("expected clean start to discard the session")
+	}
+	if _, ok := s.broker.subscriptions.Get("jobs/#", subscriber{clientID: "durable"}); ok {
+		t.Errorf("expected clean start to remove the subscriptions of the session")
+	}
+}
+
+func TestSessionEndsWithConnection(t *testing.T) {
+	s := startTestServer(t)
+	tc := dialTestConn(t, s, packet.ProtoVer311)
+	tc.connect(&packet.ConnectionRequest{ClientID: "short", CleanStart: packet.NewFlagV(true)})
+	tc.subscribe(&packet.SubscribePayload{TopicFilter: "a"})
+	tc.conn.Close()
+
+	waitFor(t, func() bool {
+		_, ok := s.sessions.Load("short")
+		return !ok
+	})
+	if s.broker.subscriptions.Len() != 0 {
+		t.Errorf("expected the subscriptions to be removed with the session")
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	s := startTestServer(t)
+	tc := dialTestConn(t, s, packet.ProtoVer5)
+	tc.connect(persistentConnect("expiring", 60))
+	tc.write(&packet.Disconnect{Properties: &packet.DisconnectProperties{SessionExpiryInterval: packet.NewFlagV[uint32](1)}})
+	tc.expectClosed()
+
+	if _, ok := s.sessions.Load("expiring"); !ok {
+		t.Fatalf("expected the session to outlive the connection")
+	}
+	waitFor(t, func() bool {
+		_, ok := s.sessions.Load("expiring")
+		return !ok
+	})
+}
+
+func TestSessionDisconnectExpiryProtocolError(t *testing.T) {
+	s := startTestServer(t)
+	tc := dialTestConn(t, s, packet.ProtoVer5)
+	tc.connect(&packet.ConnectionRequest{ClientID: "zero"})
+	tc.write(&packet.Disconnect{Properties: &packet.DisconnectProperties{SessionExpiryInterval: packet.NewFlagV[uint32](10)}})
+	if d, ok := tc.read().(*packet.Disconnect); !ok || d.ReasonCode != packet.RCProtocolError {
+		t.Errorf("expected DISCONNECT with %v", packet.RCProtocolError)
+	}
+}
+
+// waitFor polls cond until it holds or the test times out.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSessionNextPacketID(t *testing.T) {
+	sess := newSession("ids", 0)
+	sess.lastPacketID = 65534
+	sess.inflight[1] = &inflightMessage{}
+	for _, expect := range []uint16{65535, 2, 3} {
+		if id := sess.nextPacketID(); id != expect {
+			t.Errorf("expected packet id %d but got %d", expect, id)
+		}
+	}
+}
+
+func TestSessionInboundQoS2(t *testing.T) {
+	s := startTestServer(t)
+	sub := dialTestConn(t, s, packet.ProtoVer5)
+	sub.connect(&packet.ConnectionRequest{ClientID: "sub"})
+	sub.subscribe(&packet.SubscribePayload{TopicFilter: "once"})
+	pub := dialTestConn(t, s, packet.ProtoVer5)
+	pub.connect(&packet.ConnectionRequest{ClientID: "pub"})
+
+	pm := &packet.PublishMessage{QoSLevel: packet.QoS2, PacketID: 5, TopicName: "once", Payload: []byte("1")}
+	pub.write(pm)
+	if rec, ok := pub.read().(*packet.PublishReceived); !ok || rec.ReasonCode != packet.RCSuccess {
+		t.Fatalf("expected PUBREC with %v", packet.RCSuccess)
+	}
+	pm.DUP = true
+	pub.write(pm)
+	if rec, ok := pub.read().(*packet.PublishReceived); !ok || rec.ReasonCode != packet.RCSuccess {
+		t.Fatalf("expected PUBREC with %v for the retransmission", packet.RCSuccess)
+	}
+	pm.DUP = false
+	pub.write(pm)
+	if rec, ok := pub.read().(*packet.PublishReceived); !ok || rec.ReasonCode != packet.RCPacketIDInUse {
+		t.Fatalf("expected PUBREC with %v", packet.RCPacketIDInUse)
+	}
+	pub.write(&packet.PublishRelease{PacketID: 5})
+	if comp, ok := pub.read().(*packet.PublishComplete); !ok || comp.ReasonCode != packet.RCSuccess {
+		t.Fatalf("expected PUBCOMP with %v", packet.RCSuccess)
+	}
+	pub.write(&packet.PublishRelease{PacketID: 5})
+	if comp, ok := pub.read().(*packet.PublishComplete); !ok || comp.ReasonCode != packet.RCPacketIDNotFound {
+		t.Fatalf("expected PUBCOMP with %v", packet.RCPacketIDNotFound)
+	}
+
+	sub.readPublish()
+	sub.write(&packet.PingRequest{})
+	if _, ok := sub.read().(*packet.PingResponse); !ok {
+		t.Errorf("expected the message to be delivered exactly once")
+	}
+}
+
+func TestSessionOutboundQoS2(t *testing.T) {
+	s := startTestServer(t)
+	sub := dialTestConn(t, s, packet.ProtoVer5)
+	sub.connect(persistentConnect("sub", 60))
+	sub.subscribe(&packet.SubscribePayload{TopicFilter: "q/+", QoS: packet.QoS2})
+	pub := dialTestConn(t, s, packet.ProtoVer5)
+	pub.connect(&packet.ConnectionRequest{ClientID: "pub"})
+
+	pub.write(&packet.PublishMessage{QoSLevel: packet.QoS1, PacketID: 1, TopicName: "q/1"})
+	pub.read()
+	pub.write(&packet.PublishMessage{QoSLevel: packet.QoS2, PacketID: 2, TopicName: "q/2"})
+	pub.read()
+	first, second := sub.readPublish(), sub.readPublish()
+	if first.QoSLevel != packet.QoS1 || second.QoSLevel != packet.QoS2 || first.PacketID == second.PacketID {
+		t.Fatalf("expected QoS 1 and 2 messages with distinct packet ids")
+	}
+
+	// PUBREC for the QoS 2 message, then drop the connection before PUBCOMP
+	sub.write(&packet.PublishReceived{PacketID: second.PacketID})
+	if rel, ok := sub.read().(*packet.PublishRelease); !ok || rel.PacketID != second.PacketID {
+		t.Fatalf("expected PUBREL for packet %d", second.PacketID)
+	}
+	sub.write(&packet.PublishReceived{PacketID: 999})
+	if rel, ok := sub.read().(*packet.PublishRelease); !ok || rel.ReasonCode != packet.RCPacketIDNotFound {
+		t.Fatalf("expected PUBREL with %v", packet.RCPacketIDNotFound)
+	}
+	sub.conn.Close()
+
+	sub = dialTestConn(t, s, packet.ProtoVer5)
+	if connack := sub.connect(persistentConnect("sub", 60)); !connack.SessionPresent {
+		t.Fatalf("expected session present")
+	}
+	dup := sub.readPublish()
+	if !dup.DUP || dup.PacketID != first.PacketID {
+		t.Errorf("expected the QoS 1 message again with DUP set but got %v", packet.JSON(dup))
+	}
+	if rel, ok := sub.read().(*packet.PublishRelease); !ok || rel.PacketID != second.PacketID {
+		t.Fatalf("expected PUBREL to be resent for packet %d", second.PacketID)
+	}
+	sub.write(&packet.PublishAcknowledgement{PacketID: first.PacketID})
+	sub.write(&packet.PublishComplete{PacketID: second.PacketID})
+	waitFor(t, func() bool {
+		sess, _ := s.sessions.Load("sub")
+		sess.mu.Lock()
+		defer sess.mu.Unlock()
+		return len(sess.inflight) == 0
+	})
+}
+
+func TestSessionAttachCongested(t *testing.T) {
+	newSessionClient := func() *client {
+		return &client{receiveMaximum: math.MaxUint16, outbound: make(chan packet.Packet, outboundSize)}
+	}
+	deliver := func(sess *Session, i int) {
+		pm := &packet.PublishMessage{QoSLevel: packet.QoS1, TopicName: "t", Payload: []byte(strconv.Itoa(i))}
+		if !sess.deliver(&message{pm: pm, delivery: &delivery{qos: packet.QoS1}}, 100) {
+			t.Fatalf("expected message %d to be delivered", i)
+		}
+	}
+	sess := newSession("slow", 60)
+	first := newSessionClient()
+	sess.attach(first)
+	inflight := outboundSize + 20
+	for i := range inflight {
+		deliver(sess, i)
+		<-first.outbound
+	}
+	sess.detach(first)
+	for i := range 5 {
+		deliver(sess, inflight+i)
+	}
+
+	// more messages are in flight than the outbound channel holds
+	second := newSessionClient()
+	attached := make(chan struct{})
+	go func() {
+		sess.attach(second)
+		close(attached)
+	}()
+	select {
+	case <-attached:
+	case <-time.After(time.Second):
+		t.Fatalf("expected attach not to wait for the connection")
+	}
+	deliver(sess, inflight+5)
+
+	var firstID uint16
+	for i := range inflight + 6 {
+		if i == outboundSize {
+			sess.puback(firstID) // lets drain send the rest
+		}
+		var pm *packet.PublishMessage
+		select {
+		case p := <-second.outbound:
+			pm = p.(*packet.PublishMessage)
+		default:
+			t.Fatalf("expected message %d to be sent", i)
+		}
+		if i == 0 {
+			firstID = pm.PacketID
+		}
+		if string(pm.Payload) != strconv.Itoa(i) || pm.DUP != (i < inflight) {
+			t.Fatalf("expected message %d with DUP %t but got %v", i, i < inflight, packet.JSON(pm))
+		}
+	}
+}
+
+func TestSessionDrainCongested(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	c := newClient(NewServer(), conn, nil)
+	c.receiveMaximum = math.MaxUint16
+	c.session = newSession("slow", 60)
+	c.session.attach(c)
+	defer c.close()
+
+	// a full outbound channel and no message in flight, so no acknowledgement will drain the queue
+	for range outboundSize {
+		c.outbound <- &packet.PingResponse{}
+	}
+	pm := &packet.PublishMessage{QoSLevel: packet.QoS1, TopicName: "t", Payload: []byte("queued")}
+	if !c.session.deliver(&message{pm: pm, delivery: &delivery{qos: packet.QoS1}}, 100) {
+		t.Fatalf("expected the message to be queued")
+	}
+
+	go c.writeLoop()
+	tc := newTestConn(t, peer, packet.ProtoVer5)
+	for range outboundSize {
+		tc.read()
+	}
+	if pm := tc.readPublish(); string(pm.Payload) != "queued" {
+		t.Errorf("expected the queued message but got %v", packet.JSON(pm))
+	}
+}
+
+func TestSessionMessageExpiry(t *testing.T) {
+	s := startTestServer(t)
+	tc := dialTestConn(t, s, packet.ProtoVer5)
+	tc.connect(persistentConnect("offline", 60))
+	tc.subscribe(&packet.SubscribePayload{TopicFilter: "expiry", QoS: packet.QoS1})
+	tc.write(&packet.Disconnect{})
+	tc.expectClosed()
+
+	pub := dialTestConn(t, s, packet.ProtoVer5)
+	pub.connect(&packet.ConnectionRequest{ClientID: "producer"})
+	for i, payload := range []string{"stale", "fresh"} {
+		pub.write(&packet.PublishMessage{
+			QoSLevel: packet.QoS1, PacketID: uint16(i + 1), TopicName: "expiry", Payload: []byte(payload),
+			Properties: packet.PublishMessageProperties{MessageExpiryInterval: 60},
+		})
+		pub.read()
+	}
+	sess, _ := s.sessions.Load("offline")
+	sess.mu.Lock()
+	sess.queue[0].expiresAt = time.Now().Add(-time.Second)
+	sess.queue[1].expiresAt = time.Now().Add(30 * time.Second)
+	sess.mu.Unlock()
+
+	tc = dialTestConn(t, s, packet.ProtoVer5)
+	tc.connect(persistentConnect("offline", 60))
+	pm := tc.readPublish()
+	if string(pm.Payload) != "fresh" {
+		t.Fatalf("expected the expired message to be discarded but got %v", packet.JSON(pm))
+	}
+	if expiry := pm.Properties.MessageExpiryInterval; expiry == 0 || expiry > 30 {
+		t.Errorf("expected the remaining expiry interval of at most 30 seconds but got %d", expiry)
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+// ShareStrategy is how a shared subscription chooses the member that receives a message.
+type ShareStrategy byte
+
+const (
+	ShareRoundRobin ShareStrategy = iota // members take turns
+	ShareRandom                          // a random member
+	ShareSticky                          // the same member for as long as it stays subscribed and connected
+	ShareHash                            // the member chosen by a hash of the publisher's client id
+)
+
+// shareKey identifies a shared subscription, '$share/{group}/{filter}'.
+type shareKey struct {
+	group  string
+	filter string
+}
+
+type shareMember struct {
+	clientID string
+	sub      *packet.SubscribePayload
+}
+
+// shareBalancer distributes the messages of shared subscriptions among their members.
+type shareBalancer struct {
+	strategy ShareStrategy
+	online   func(clientID string) bool
+
+	mu     sync.Mutex
+	next   map[shareKey]uint64 // round robin position
+	sticky map[shareKey]string // member chosen by the sticky strategy
+}
+
+func newShareBalancer(strategy ShareStrategy, online func(clientID string) bool) *shareBalancer {
+	return &shareBalancer{
+		strategy: strategy,
+		online:   online,
+		next:     map[shareKey]uint64{},
+		sticky:   map[shareKey]string{},
+	}
+}
+
+// pick chooses the member of a shared subscription receiving a message, preferring members whose client
+// is connected. It returns nil if there are no members.
+func (sb *shareBalancer) pick(k shareKey, members []*shareMember, publisher string) *delivery {
+	if len(members) == 0 {
+		return nil
+	}
+	var online []*shareMember
+	for _, m := range members {
+		if sb.online(m.clientID) {
+			online = append(online, m)
+		}
+	}
+	if len(online) > 0 {
+		members = online
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].clientID < members[j].clientID
+	})
+
+	var m *shareMember
+	switch sb.strategy {
+	case ShareRandom:
+		m = members[rand.Intn(len(members))]
+	case ShareHash:
+		h := fnv.New32a()
+		h.Write([]byte(publisher))
+		m = members[h.Sum32()%uint32(len(members))]
+	case ShareSticky:
+		sb.mu.Lock()
+		for _, member := range members {
+			if member.clientID == sb.sticky[k] {
+				m = member
+				break
+			}
+		}
+		if m == nil {
+			m = members[rand.Intn(len(members))]
+			sb.sticky[k] = m.clientID
+		}
+		sb.mu.Unlock()
+	default:
+		sb.mu.Lock()
+		m = members[sb.next[k]%uint64(len(members))]
+		sb.next[k]++
+		sb.mu.Unlock()
+	}
+	return &delivery{clientID: m.clientID, qos: m.sub.QoS, retainAsPublished: m.sub.RetainAsPublished,
+		subscriptionIDs: subscriptionIDs(m.sub), share: &k}
+}
+
+// subscriptionIDs returns the subscription identifiers a delivery from a subscription carries.
+func subscriptionIDs(sub *packet.SubscribePayload) []int {
+	if sub.SubscriptionID == 0 {
+		return nil
+	}
+	return []int{sub.SubscriptionID}
+}
+
+// subscriptionFilter returns the topic filter of a subscription, without the share name of a shared subscription.
+func subscriptionFilter(filter string) string {
+	if _, shared, ok := packet.ParseSharedSubscription(filter); ok {
+		return shared
+	}
+	return filter
+}
+
+// redistribute sends the messages of shared subscriptions that the client of a session did not receive
+// to other members, or back to the session if there is none.
+func (s *Server) redistribute(sess *Session, messages []*message) {
+	for _, msg := range messages {
+		d := s.broker.rematch(*msg.delivery.share, msg.publisher, sess.ClientID)
+		if d == nil {
+			d = msg.delivery
+		}
+		s.forward(msg, d)
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+func shareMembers(ids ...string) []*shareMember {
+	members := make([]*shareMember, 0, len(ids))
+	for _, id := range ids {
+		members = append(members, &shareMember{clientID: id, sub: &packet.SubscribePayload{QoS: packet.QoS1}})
+	}
+	return members
+}
+
+func TestShareBalancer(t *testing.T) {
+	k := shareKey{group: "g", filter: "t"}
+	online := func(clientID string) bool { return clientID != "offline" }
+	pick := func(sb *shareBalancer, publisher string, ids ...string) string {
+		return sb.pick(k, shareMembers(ids...), publisher).clientID
+	}
+
+	rr := newShareBalancer(ShareRoundRobin, online)
+	for _, expect := range []string{"a", "b", "c", "a"} {
+		if got := pick(rr, "p", "c", "b", "a"); got != expect {
+			t.Errorf("round robin expected %s but got %s", expect, got)
+		}
+	}
+	if got := pick(rr, "p", "offline", "b"); got != "b" {
+		t.Errorf("expected the online member but got %s", got)
+	}
+	if got := pick(rr, "p", "offline"); got != "offline" {
+		t.Errorf("expected the offline member when no member is online but got %s", got)
+	}
+
+	hash := newShareBalancer(ShareHash, online)
+	first := pick(hash, "publisher-1", "a", "b", "c")
+	for i := 0; i < 10; i++ {
+		if got := pick(hash, "publisher-1", "c", "a", "b"); got != first {
+			t.Fatalf("expected the same member %s for a publisher but got %s", first, got)
+		}
+	}
+
+	sticky := newShareBalancer(ShareSticky, online)
+	chosen := pick(sticky, "p", "a", "b", "c")
+	for i := 0; i < 10; i++ {
+		if got := pick(sticky, "p", "a", "b", "c"); got != chosen {
+			t.Fatalf("expected the sticky member %s but got %s", chosen, got)
+		}
+	}
+	if got := pick(sticky, "p", "x"); got != "x" {
+		t.Errorf("expected a new member once the sticky one left but got %s", got)
+	}
+
+	random := newShareBalancer(ShareRandom, online)
+	if got := pick(random, "p", "a", "b"); got != "a" && got != "b" {
+		t.Errorf("expected a member but got %s", got)
+	}
+	if d := random.pick(k, nil, "p"); d != nil {
+		t.Errorf("expected no delivery without members")
+	}
+}
+
+func TestBrokerSharedMatch(t *testing.T) {
+	b := newBroker(ShareRoundRobin, func(string) bool { return true })
+	b.subscribe("c1", &packet.SubscribePayload{TopicFilter: "a/+"})
+	b.subscribe("c1", &packet.SubscribePayload{TopicFilter: "$share/g/a/+", QoS: packet.QoS1})
+	b.subscribe("c2", &packet.SubscribePayload{TopicFilter: "$share/g/a/+", QoS: packet.QoS2})
+	b.subscribe("c2", &packet.SubscribePayload{TopicFilter: "$share/h/a/#"})
+
+	deliveries := b.match("p", "a/b")
+	if len(deliveries) != 3 {
+		t.Fatalf("expected 3 deliveries but got %d", len(deliveries))
+	}
+	shares := map[string]string{}
+	for _, d := range deliveries {
+		if d.share != nil {
+			shares[d.share.group] = d.clientID
+		}
+	}
+	if shares["g"] != "c1" || shares["h"] != "c2" {
+		t.Errorf("unexpected shared deliveries %v", shares)
+	}
+	if d := b.rematch(shareKey{group: "g", filter: "a/+"}, "p", "c1"); d == nil || d.clientID != "c2" || d.qos != packet.QoS2 {
+		t.Errorf("expected c2 to take over the message")
+	}
+	if d := b.rematch(shareKey{group: "h", filter: "a/#"}, "p", "c2"); d != nil {
+		t.Errorf("expected no other member")
+	}
+}
+
+func TestServerSharedSubscription(t *testing.T) {
+	s := startTestServer(t)
+	a := dialTestConn(t, s, packet.ProtoVer5)
+	a.connect(&packet.ConnectionRequest{ClientID: "a"})
+	a.subscribe(&packet.SubscribePayload{TopicFilter: "$share/workers/jobs", QoS: packet.QoS1})
+	b := dialTestConn(t, s, packet.ProtoVer5)
+	b.connect(&packet.ConnectionRequest{ClientID: "b"})
+	b.subscribe(&packet.SubscribePayload{TopicFilter: "$share/workers/jobs", QoS: packet.QoS1})
+
+	pub := dialTestConn(t, s, packet.ProtoVer5)
+	pub.connect(&packet.ConnectionRequest{ClientID: "pub"})
+	publish := func(id uint16, payload string) {
+		pub.write(&packet.PublishMessage{QoSLevel: packet.QoS1, PacketID: id, TopicName: "jobs", Payload: []byte(payload)})
+		pub.read()
+	}
+	publish(1, "one")
+	publish(2, "two")
+	pm := a.readPublish()
+	if string(pm.Payload) != "one" {
+		t.Errorf("expected a to receive the first job but got %s", pm.Payload)
+	}
+	a.write(&packet.PublishAcknowledgement{PacketID: pm.PacketID})
+	pm = b.readPublish()
+	if string(pm.Payload) != "two" {
+		t.Errorf("expected b to receive the second job but got %s", pm.Payload)
+	}
+	b.write(&packet.PublishAcknowledgement{PacketID: pm.PacketID})
+
+	// a leaves without acknowledging its next job, which moves to b
+	publish(3, "three")
+	a.readPublish()
+	a.conn.Close()
+	if pm := b.readPublish(); string(pm.Payload) != "three" {
+		t.Errorf("expected b to take over the unacknowledged job but got %s", pm.Payload)
+	}
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+// TLSConfig configures the TLS listener.
+type TLSConfig struct {
+	CertFile string `json:"cert_file"` // PEM encoded certificate chain of the server
+	KeyFile  string `json:"key_file"`  // PEM encoded private key of the server
+
+	// ClientCAFile is a PEM file of the CAs client certificates are verified against.
+	// Client certificates are not requested without it.
+	ClientCAFile string `json:"client_ca_file"`
+	// RequireClientCert refuses clients without a certificate signed by one of the CAs of ClientCAFile.
+	// Otherwise a client may connect without a certificate, but not with one that fails verification.
+	RequireClientCert bool `json:"require_client_cert"`
+}
+
+var errClientCARequired = errors.New("client certificates cannot be required without client CAs")
+
+func (config *TLSConfig) validate() error {
+	if config.RequireClientCert && config.ClientCAFile == "" {
+		return errClientCARequired
+	}
+	return nil
+}
+
+// certCheckInterval is how often the files of a TLSConfig are checked for changes, at most.
+const certCheckInterval = time.Second
+
+// certReloader provides the certificate and client CAs of a TLSConfig to new connections,
+// loading the files again when they change so certificates can be rotated without a restart.
+type certReloader struct {
+	config TLSConfig
+
+	mu        sync.Mutex
+	checked   time.Time
+	modTimes  []time.Time // of the files the current configuration was loaded from
+	tlsConfig *tls.Config
+}
+
+func newCertReloader(config TLSConfig) (*certReloader, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	r := &certReloader{config: config}
+	modTimes, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTimes); err != nil {
+		return nil, err
+	}
+	r.checked = time.Now()
+	return r, nil
+}
+
+// listener wraps a listener so its connections use TLS.
+func (r *certReloader) listener(l net.Listener) net.Listener {
+	return tls.NewListener(l, &tls.Config{GetConfigForClient: r.configForClient})
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.config.CertFile, r.config.KeyFile}
+	if r.config.ClientCAFile != "" {
+		files = append(files, r.config.ClientCAFile)
+	}
+	return files
+}
+
+func (r *certReloader) stat() ([]time.Time, error) {
+	var modTimes []time.Time
+	for _, file := range r.files() {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes = append(modTimes, fi.ModTime())
+	}
+	return modTimes, nil
+}
+
+func (r *certReloader) load(modTimes []time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if r.config.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no certificates found", r.config.ClientCAFile)
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if r.config.RequireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	r.tlsConfig = config
+	r.modTimes = modTimes
+	return nil
+}
+
+// configForClient returns the configuration for a new connection, loading the files again if they changed.
+// A change that fails to load, such as a certificate written before its key, keeps the previous configuration.
+func (r *certReloader) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now := time.Now(); now.Sub(r.checked) >= certCheckInterval {
+		r.checked = now
+		modTimes, err := r.stat()
+		if err == nil && !slices.EqualFunc(modTimes, r.modTimes, time.Time.Equal) {
+			err = r.load(modTimes)
+		}
+		if err != nil {
+			log.Printf("failed to reload TLS certificates: %v", err)
+		}
+	}
+	return r.tlsConfig, nil
+}
+
+// verifiedCertificate returns the client certificate of a TLS connection if it was verified, or nil.
+func verifiedCertificate(conn net.Conn) *x509.Certificate {
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	if chains := tc.ConnectionState().VerifiedChains; len(chains) > 0 {
+		return chains[0][0]
+	}
+	return nil
+}
+
+// CertificateIdentity returns the identity of a client certificate: its subject common name,
+// or its first DNS subject alternative name if the common name is empty.
+func CertificateIdentity(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" || len(cert.DNSNames) == 0 {
+		return cert.Subject.CommonName
+	}
+	return cert.DNSNames[0]
+}
+
+// NewCertificateAuthenticator creates an Authenticator that accepts the clients that presented a verified
+// certificate on a TLS connection, and passes the others to fallback.
+// The username of a client with a certificate becomes the identity of the certificate, so that authorizers
+// see the identity the certificate proves. Such a client is refused with RCBadUsernameOrPassword if it sends
+// another username, and with RClientIDNotValid if it sends a client id other than the identity.
+// Clients without a certificate are refused with RCNotAuthorized if fallback is nil.
+// Enhanced authentication is not supported.
+func NewCertificateAuthenticator(fallback Authenticator) Authenticator {
+	return &certificateAuthenticator{fallback: fallback}
+}
+
+type certificateAuthenticator struct {
+	fallback Authenticator
+}
+
+func (ca *certificateAuthenticator) Authenticate(info *ClientInfo, cr *packet.ConnectionRequest) (packet.RCode, []byte) {
+	if info.Certificate != nil {
+		identity := CertificateIdentity(info.Certificate)
+		switch {
+		case identity == "":
+			return packet.RCNotAuthorized, nil
+		case cr.Username.Flag() && string(cr.Username.Value()) != identity:
+			return packet.RCBadUsernameOrPassword, nil
+		case cr.ClientID != "" && cr.ClientID != identity:
+			return packet.RClientIDNotValid, nil
+		}
+		info.Username = identity
+		return packet.RCSuccess, nil
+	}
+	if ca.fallback == nil {
+		return packet.RCNotAuthorized, nil
+	}
+	return ca.fallback.Authenticate(info, cr)
+}
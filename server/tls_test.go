@@ -0,0 +1,270 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	tlsCert tls.Certificate
+}
+
+// newTestCert creates a certificate signed by parent, or a self signed CA if parent is nil.
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, tlsCert: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}}
+}
+
+// writeFiles writes the PEM encoded certificate and key to a directory.
+func (tc *testCert) writeFiles(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(tc.key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tc.cert.Raw}), 0o600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+type testPKI struct {
+	ca     *testCert
+	config TLSConfig
+	roots  *x509.CertPool
+}
+
+// newTestPKI creates a CA and a server certificate for 127.0.0.1 signed by it.
+func newTestPKI(t *testing.T, requireClientCert bool) *testPKI {
+	t.Helper()
+	dir := t.TempDir()
+	ca := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test ca"}}, nil)
+	caFile, _ := ca.writeFiles(t, t.TempDir())
+	server := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "broker"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	certFile, keyFile := server.writeFiles(t, dir)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	return &testPKI{
+		ca:     ca,
+		config: TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, RequireClientCert: requireClientCert},
+		roots:  roots,
+	}
+}
+
+// clientCert creates a client certificate signed by the CA.
+func (pki *testPKI) clientCert(t *testing.T, commonName string) *testCert {
+	return newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName},
+		DNSNames:    []string{commonName + ".devices.example"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, pki.ca)
+}
+
+// dial opens a TLS connection to the server, presenting cert unless it is nil.
+func (pki *testPKI) dial(t *testing.T, s *Server, cert *testCert) *testConn {
+	t.Helper()
+	config := &tls.Config{RootCAs: pki.roots}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{cert.tlsCert}
+	}
+	conn, err := tls.Dial("tcp", s.Addr().String(), config)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return newTestConn(t, conn, packet.ProtoVer5)
+}
+
+// infoRecorder is an Authenticator that records the ClientInfo of the clients before passing them on.
+type infoRecorder struct {
+	Authenticator
+	mu    sync.Mutex
+	infos []*ClientInfo
+}
+
+func (r *infoRecorder) Authenticate(info *ClientInfo, cr *packet.ConnectionRequest) (packet.RCode, []byte) {
+	r.mu.Lock()
+	r.infos = append(r.infos, info)
+	r.mu.Unlock()
+	return r.Authenticator.Authenticate(info, cr)
+}
+
+func (r *infoRecorder) last() *ClientInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.infos[len(r.infos)-1]
+}
+
+func TestServerTLS(t *testing.T) {
+	t.Run("required client certificate", func(t *testing.T) {
+		pki := newTestPKI(t, true)
+		auth := &infoRecorder{Authenticator: NewCertificateAuthenticator(nil)}
+		s := startTestServer(t, WithTLSListener("127.0.0.1:0", pki.config, ListenerConfig{}), WithAuthenticator(auth))
+
+		tc := pki.dial(t, s, pki.clientCert(t, "device-1"))
+		if connack := tc.connect(&packet.ConnectionRequest{ClientID: "device-1"}); connack.ConnectReasonCode != packet.RCSuccess {
+			t.Fatalf("expected %v but got %v", packet.RCSuccess, connack.ConnectReasonCode)
+		}
+		cert := auth.last().Certificate
+		if cert == nil || cert.Subject.CommonName != "device-1" || cert.DNSNames[0] != "device-1.devices.example" {
+			t.Errorf("expected the certificate of device-1 in the client info but got %v", cert)
+		}
+
+		tc = pki.dial(t, s, nil)
+		tc.write(&packet.ConnectionRequest{ProtocolName: packet.FixedProtocolNameV5, ProtocolVersion: packet.ProtoVer5})
+		tc.expectClosed()
+
+		// a certificate signed by another CA is refused
+		other := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "other"}}, nil)
+		tc = pki.dial(t, s, newTestCert(t, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "device-2"},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, other))
+		tc.write(&packet.ConnectionRequest{ProtocolName: packet.FixedProtocolNameV5, ProtocolVersion: packet.ProtoVer5})
+		tc.expectClosed()
+	})
+
+	t.Run("optional client certificate", func(t *testing.T) {
+		pki := newTestPKI(t, false)
+		s := startTestServer(t, WithTLSListener("127.0.0.1:0", pki.config, ListenerConfig{}), WithAuthenticator(NewCertificateAuthenticator(nil)))
+
+		tc := pki.dial(t, s, nil)
+		if connack := tc.connect(&packet.ConnectionRequest{ClientID: "anonymous"}); connack.ConnectReasonCode != packet.RCNotAuthorized {
+			t.Errorf("expected %v without a certificate but got %v", packet.RCNotAuthorized, connack.ConnectReasonCode)
+		}
+		tc = pki.dial(t, s, pki.clientCert(t, "device-1"))
+		if connack := tc.connect(&packet.ConnectionRequest{ClientID: "device-1"}); connack.ConnectReasonCode != packet.RCSuccess {
+			t.Errorf("expected %v with a certificate but got %v", packet.RCSuccess, connack.ConnectReasonCode)
+		}
+	})
+
+	t.Run("certificate identity", func(t *testing.T) {
+		pki := newTestPKI(t, true)
+		acl, err := NewACLFileAuthorizer(writeLinesFile(t, "acl", "pattern devices/%u/#", "user admin", "topic secret/#"))
+		if err != nil {
+			t.Fatalf("failed to load ACL file: %v", err)
+		}
+		s := startTestServer(t, WithTLSListener("127.0.0.1:0", pki.config, ListenerConfig{}),
+			WithAuthenticator(NewCertificateAuthenticator(nil)), WithAuthorizer(acl))
+		cert := pki.clientCert(t, "device-1")
+
+		tc := pki.dial(t, s, cert)
+		if connack := tc.connect(&packet.ConnectionRequest{ClientID: "device-1", Username: packet.NewFlagV([]byte("admin"))}); connack.ConnectReasonCode != packet.RCBadUsernameOrPassword {
+			t.Errorf("expected %v for a username other than the certificate identity but got %v", packet.RCBadUsernameOrPassword, connack.ConnectReasonCode)
+		}
+		tc = pki.dial(t, s, cert)
+		if connack := tc.connect(&packet.ConnectionRequest{ClientID: "admin"}); connack.ConnectReasonCode != packet.RClientIDNotValid {
+			t.Errorf("expected %v for a client id other than the certificate identity but got %v", packet.RClientIDNotValid, connack.ConnectReasonCode)
+		}
+
+		tc = pki.dial(t, s, cert)
+		if connack := tc.connect(&packet.ConnectionRequest{ClientID: "device-1"}); connack.ConnectReasonCode != packet.RCSuccess {
+			t.Fatalf("expected %v but got %v", packet.RCSuccess, connack.ConnectReasonCode)
+		}
+		codes := tc.subscribe(&packet.SubscribePayload{TopicFilter: "secret/#"}, &packet.SubscribePayload{TopicFilter: "devices/device-1/#"})
+		if codes[0] != packet.RCNotAuthorized || codes[1] != packet.RCGrantedQoS0 {
+			t.Errorf("expected the ACL to apply to the certificate identity but got %v", codes)
+		}
+	})
+
+	t.Run("invalid certificate files", func(t *testing.T) {
+		s := NewServer(WithTLSListener("127.0.0.1:0", TLSConfig{CertFile: "missing.pem", KeyFile: "missing.pem"}, ListenerConfig{}))
+		if err := s.Start(); err == nil {
+			t.Errorf("expected Start to fail")
+		}
+	})
+
+	t.Run("required client certificate without CAs", func(t *testing.T) {
+		config := newTestPKI(t, true).config
+		config.ClientCAFile = ""
+		s := NewServer(WithTLSListener("127.0.0.1:0", config, ListenerConfig{}))
+		if err := s.Start(); err != errClientCARequired {
+			t.Errorf("expected %v but got %v", errClientCARequired, err)
+		}
+	})
+}
+
+func TestCertReloader(t *testing.T) {
+	pki := newTestPKI(t, false)
+	r, err := newCertReloader(pki.config)
+	if err != nil {
+		t.Fatalf("failed to load certificates: %v", err)
+	}
+	leaf := func() []byte {
+		config, _ := r.configForClient(nil)
+		return config.Certificates[0].Certificate[0]
+	}
+	first := leaf()
+
+	// a certificate without its key keeps the previous configuration
+	rotated := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "broker"}}, pki.ca)
+	dir := t.TempDir()
+	certFile, keyFile := rotated.writeFiles(t, dir)
+	data, _ := os.ReadFile(certFile)
+	os.WriteFile(pki.config.CertFile, data, 0o600)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(pki.config.CertFile, later, later)
+	r.checked = time.Time{}
+	if !bytes.Equal(leaf(), first) {
+		t.Errorf("expected the previous certificate while the key does not match")
+	}
+
+	data, _ = os.ReadFile(keyFile)
+	os.WriteFile(pki.config.KeyFile, data, 0o600)
+	os.Chtimes(pki.config.KeyFile, later, later)
+	if !bytes.Equal(leaf(), first) {
+		t.Errorf("expected the files to be checked at most every %v", certCheckInterval)
+	}
+	r.checked = time.Time{}
+	if !bytes.Equal(leaf(), rotated.cert.Raw) {
+		t.Errorf("expected the rotated certificate")
+	}
+}
@@ -0,0 +1,343 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	websocketGUID        = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	websocketSubprotocol = "mqtt" // [MQTT-6.0.0-3]
+
+	websocketHandshakeTimeout = 10 * time.Second // time allowed to send the HTTP request of the opening handshake
+	closeFrameTimeout         = time.Second      // time allowed to write a close frame
+)
+
+// WebSocket frame opcodes. [RFC 6455 5.2]
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// WebSocket close status codes. [RFC 6455 7.4.1]
+const (
+	wsCloseNormal      = 1000
+	wsCloseProtocol    = 1002
+	wsCloseUnsupported = 1003
+	wsCloseTooLarge    = 1009
+)
+
+var errWebSocketProtocol = errors.New("websocket protocol error")
+
+// WebSocketHandler returns an http.Handler that upgrades requests to WebSocket connections carrying MQTT
+// and serves them like the connections of a listener with config, to mount the server on an existing HTTP server.
+func (s *Server) WebSocketHandler(config ListenerConfig) (http.Handler, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	e := &endpoint{config: config}
+	return &websocketHandler{serve: func(conn net.Conn) {
+		s.serveConn(conn, e)
+	}}, nil
+}
+
+// upgradeListener is a net.Listener accepting the connections upgraded by an HTTP server.
+type upgradeListener struct {
+	net.Listener // served by the HTTP server
+	server       *http.Server
+	conns        chan net.Conn
+	done         chan struct{}
+	closeOnce    sync.Once
+}
+
+func newUpgradeListener(l net.Listener) *upgradeListener {
+	ul := &upgradeListener{Listener: l, conns: make(chan net.Conn), done: make(chan struct{})}
+	ul.server = &http.Server{Handler: &websocketHandler{serve: ul.handoff}, ReadHeaderTimeout: websocketHandshakeTimeout}
+	go ul.server.Serve(l)
+	return ul
+}
+
+// handoff passes an upgraded connection to Accept.
+func (ul *upgradeListener) handoff(conn net.Conn) {
+	select {
+	case ul.conns <- conn:
+	case <-ul.done:
+		conn.Close()
+	}
+}
+
+func (ul *upgradeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ul.conns:
+		return conn, nil
+	case <-ul.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops the HTTP server. Upgraded connections are not closed.
+func (ul *upgradeListener) Close() error {
+	var err error
+	ul.closeOnce.Do(func() {
+		close(ul.done)
+		err = ul.server.Close()
+	})
+	return err
+}
+
+type websocketHandler struct {
+	serve func(conn net.Conn)
+}
+
+// ServeHTTP completes the opening handshake of a WebSocket connection with the "mqtt" subprotocol. [RFC 6455 4.2]
+func (h *websocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		w.Header().Set("Upgrade", "websocket")
+		http.Error(w, "expected a WebSocket upgrade", http.StatusUpgradeRequired)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusBadRequest)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if nonce, err := base64.StdEncoding.DecodeString(key); err != nil || len(nonce) != 16 {
+		http.Error(w, "invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return
+	}
+	if !headerContains(r.Header, "Sec-WebSocket-Protocol", websocketSubprotocol) { // [MQTT-6.0.0-4]
+		http.Error(w, "the mqtt subprotocol is required", http.StatusBadRequest)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection cannot be upgraded", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	_, err = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: "+base64.StdEncoding.EncodeToString(accept[:])+"\r\n"+
+		"Sec-WebSocket-Protocol: "+websocketSubprotocol+"\r\n\r\n")
+	if err != nil {
+		conn.Close()
+		return
+	}
+	h.serve(newWebSocketConn(conn, rw.Reader))
+}
+
+// headerContains reports whether a comma separated header lists a token, ignoring case.
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// websocketConn is a net.Conn carrying MQTT in the binary frames of a WebSocket connection. [MQTT-6.0.0-1]
+// Reads return the payloads of the data frames as a byte stream, so packets may span frames. [MQTT-6.0.0-2]
+// Each Write is sent as one binary frame.
+type websocketConn struct {
+	net.Conn
+	br *bufio.Reader
+
+	// read state, only used by the reader
+	remaining  uint64 // bytes left in the payload of the current data frame
+	mask       [4]byte
+	maskPos    int
+	fragmented bool // a data message continues in the next data frame
+
+	wmu       sync.Mutex // serializes frames from the write loop and control frames from the reader
+	closeSent bool       // no frame may follow a close frame
+}
+
+func newWebSocketConn(conn net.Conn, br *bufio.Reader) *websocketConn {
+	return &websocketConn{Conn: conn, br: br}
+}
+
+func (c *websocketConn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.br.Read(p)
+	for i := range p[:n] {
+		p[i] ^= c.mask[c.maskPos]
+		c.maskPos = (c.maskPos + 1) & 3
+	}
+	c.remaining -= uint64(n)
+	return n, err
+}
+
+// nextFrame reads frame headers until one starts a data payload, answering the control frames on the way.
+func (c *websocketConn) nextFrame() error {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0F
+	if header[0]&0x70 != 0 || header[1]&0x80 == 0 { // no extensions are negotiated, and clients mask every frame
+		return c.fail(wsCloseProtocol)
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+		if length > 1<<63-1 {
+			return c.fail(wsCloseTooLarge)
+		}
+	}
+	if _, err := io.ReadFull(c.br, c.mask[:]); err != nil {
+		return err
+	}
+	c.maskPos = 0
+
+	if opcode >= wsClose {
+		if !fin || length > 125 { // control frames are not fragmented and have short payloads
+			return c.fail(wsCloseProtocol)
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			return err
+		}
+		for i := range payload {
+			payload[i] ^= c.mask[i&3]
+		}
+		switch opcode {
+		case wsClose:
+			c.closeWith(wsCloseNormal)
+			return io.EOF
+		case wsPing:
+			return c.writeFrame(wsPong, payload)
+		case wsPong:
+			return nil
+		}
+		return c.fail(wsCloseProtocol)
+	}
+
+	switch opcode {
+	case wsBinary:
+		if c.fragmented {
+			return c.fail(wsCloseProtocol)
+		}
+	case wsContinuation:
+		if !c.fragmented {
+			return c.fail(wsCloseProtocol)
+		}
+	case wsText: // MQTT is only carried in binary frames [MQTT-6.0.0-1]
+		return c.fail(wsCloseUnsupported)
+	default:
+		return c.fail(wsCloseProtocol)
+	}
+	c.fragmented = !fin
+	c.remaining = length
+	return nil
+}
+
+func (c *websocketConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(wsBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeFrame writes an unmasked frame in a single write.
+func (c *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	frame := appendFrame(make([]byte, 0, 10+len(payload)), opcode, payload)
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+func appendFrame(frame []byte, opcode byte, payload []byte) []byte {
+	frame = append(frame, 0x80|opcode)
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	return append(frame, payload...)
+}
+
+// fail closes the connection with a status code after the client broke the WebSocket protocol.
+func (c *websocketConn) fail(status uint16) error {
+	c.closeWith(status)
+	return errWebSocketProtocol
+}
+
+// closeWith sends a close frame with a status code, unless one was sent already.
+func (c *websocketConn) closeWith(status uint16) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.writeClose(status)
+}
+
+// writeClose writes a close frame unless one was sent already, giving up after closeFrameTimeout
+// so that a client that stopped reading cannot delay closing. The caller holds wmu.
+func (c *websocketConn) writeClose(status uint16) {
+	if c.closeSent {
+		return
+	}
+	c.closeSent = true
+	c.Conn.SetWriteDeadline(time.Now().Add(closeFrameTimeout))
+	c.Conn.Write(appendFrame(nil, wsClose, binary.BigEndian.AppendUint16(nil, status)))
+}
+
+// Close sends a close frame and closes the underlying connection. The close frame is skipped while
+// another frame is being written, since closing the connection is what unblocks that write.
+func (c *websocketConn) Close() error {
+	if c.wmu.TryLock() {
+		c.writeClose(wsCloseNormal)
+		c.wmu.Unlock()
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+// wsTestConn is the client side of a WebSocket connection. Writes are sent as masked binary frames
+// and reads return the payloads of the frames from the server.
+type wsTestConn struct {
+	net.Conn
+	t       *testing.T
+	br      *bufio.Reader
+	pending []byte // payload left from the last binary frame
+}
+
+// dialWebSocket opens a WebSocket connection to the server, offering the given subprotocol.
+func dialWebSocket(t *testing.T, s *Server, subprotocol string) (*wsTestConn, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	req, _ := http.NewRequest(http.MethodGet, "http://"+s.Addr().String()+"/mqtt", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	if subprotocol != "" {
+		req.Header.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("failed to write handshake: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatalf("failed to read handshake: %v", err)
+	}
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		accept := sha1.Sum([]byte(key + websocketGUID))
+		if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(accept[:]) {
+			t.Errorf("unexpected Sec-WebSocket-Accept %q", resp.Header.Get("Sec-WebSocket-Accept"))
+		}
+	}
+	return &wsTestConn{Conn: conn, t: t, br: br}, resp
+}
+
+// writeFrame writes a masked frame.
+func (c *wsTestConn) writeFrame(fin bool, opcode byte, payload []byte) {
+	c.t.Helper()
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, 0x80|byte(n))
+	default:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	}
+	mask := [4]byte{1, 2, 3, 4}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i&3])
+	}
+	if _, err := c.Conn.Write(frame); err != nil {
+		c.t.Fatalf("failed to write frame: %v", err)
+	}
+}
+
+// readFrame reads an unmasked frame.
+func (c *wsTestConn) readFrame() (opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return 0, nil, err
+	}
+	if header[1]&0x80 != 0 {
+		c.t.Errorf("expected an unmasked frame from the server")
+	}
+	length := int(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	payload = make([]byte, length)
+	_, err = io.ReadFull(c.br, payload)
+	return header[0] & 0x0F, payload, err
+}
+
+func (c *wsTestConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, err
+		}
+		if opcode != wsBinary {
+			c.t.Errorf("expected a binary frame but got opcode %d", opcode)
+			return 0, io.EOF
+		}
+		c.pending = payload
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *wsTestConn) Write(p []byte) (int, error) {
+	c.writeFrame(true, wsBinary, p)
+	return len(p), nil
+}
+
+func encodePacket(t *testing.T, ver packet.ProtocolVersion, p packet.Packet) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := packet.NewWriter(&buf).WritePacket(ver, p); err != nil {
+		t.Fatalf("failed to encode %v: %v", p.Type(), err)
+	}
+	return buf.Bytes()
+}
+
+func TestServerWebSocket(t *testing.T) {
+	s := startTestServer(t, WithWebSocketListener("127.0.0.1:0", ListenerConfig{}))
+
+	t.Run("connect", func(t *testing.T) {
+		ws, resp := dialWebSocket(t, s, "mqtt")
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("expected status %d but got %d", http.StatusSwitchingProtocols, resp.StatusCode)
+		}
+		if resp.Header.Get("Sec-WebSocket-Protocol") != "mqtt" {
+			t.Errorf("expected subprotocol mqtt but got %q", resp.Header.Get("Sec-WebSocket-Protocol"))
+		}
+
+		// the CONNECT packet spans a fragmented message
+		connect := encodePacket(t, packet.ProtoVer5, &packet.ConnectionRequest{
+			ProtocolName:    packet.FixedProtocolNameV5,
+			ProtocolVersion: packet.ProtoVer5,
+			ClientID:        "ws",
+		})
+		ws.writeFrame(false, wsBinary, connect[:3])
+		ws.writeFrame(true, wsContinuation, connect[3:])
+
+		tc := newTestConn(t, ws, packet.ProtoVer5)
+		if connack, ok := tc.read().(*packet.ConnectAcknowledgement); !ok || connack.ConnectReasonCode != packet.RCSuccess {
+			t.Fatalf("expected a successful CONNACK")
+		}
+
+		tc.write(&packet.PingRequest{})
+		if _, ok := tc.read().(*packet.PingResponse); !ok {
+			t.Errorf("expected PINGRESP")
+		}
+	})
+
+	t.Run("ping", func(t *testing.T) {
+		ws, _ := dialWebSocket(t, s, "mqtt")
+		ws.writeFrame(true, wsPing, []byte("hi"))
+		opcode, payload, err := ws.readFrame()
+		if err != nil || opcode != wsPong || string(payload) != "hi" {
+			t.Errorf("expected a pong echoing the ping but got opcode %d %q (%v)", opcode, payload, err)
+		}
+	})
+
+	t.Run("no subprotocol", func(t *testing.T) {
+		_, resp := dialWebSocket(t, s, "")
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status %d but got %d", http.StatusBadRequest, resp.StatusCode)
+		}
+	})
+
+	t.Run("text frame", func(t *testing.T) {
+		ws, _ := dialWebSocket(t, s, "mqtt")
+		ws.writeFrame(true, wsText, []byte("hello"))
+		opcode, payload, err := ws.readFrame()
+		if err != nil || opcode != wsClose || len(payload) < 2 || binary.BigEndian.Uint16(payload) != wsCloseUnsupported {
+			t.Errorf("expected a close frame with status %d but got opcode %d %v (%v)", wsCloseUnsupported, opcode, payload, err)
+		}
+	})
+}
+
+func TestWebSocketConnCloseStalledPeer(t *testing.T) {
+	server, peer := net.Pipe()
+	defer peer.Close()
+	ws := newWebSocketConn(server, bufio.NewReader(server))
+
+	// the peer never reads, so the write blocks while holding the write lock
+	written := make(chan error, 1)
+	go func() {
+		_, err := ws.Write(make([]byte, 1024))
+		written <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- ws.Close()
+	}()
+	select {
+	case <-closed:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("expected Close to return while a write is blocked")
+	}
+	select {
+	case err := <-written:
+		if err == nil {
+			t.Errorf("expected the blocked write to fail")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected the blocked write to be released by Close")
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"time"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+// willMessage creates the PUBLISH of the will message of a CONNECT, or returns nil if it has none.
+func willMessage(cr *packet.ConnectionRequest) *packet.PublishMessage {
+	if !cr.Will.Flag() {
+		return nil
+	}
+	will := cr.Will.Value()
+	pm := &packet.PublishMessage{
+		QoSLevel:  will.Qos.Value(),
+		Retain:    will.Retain,
+		TopicName: will.Topic,
+		Payload:   will.Payload,
+	}
+	if props := will.Properties; props != nil {
+		pm.Properties = packet.PublishMessageProperties{
+			PayloadFormatIndicator: packet.PayloadFormatIndicator(props.PayloadFormat.Value()),
+			MessageExpiryInterval:  props.MessageExpiryInterval,
+			ResponseTopic:          props.ResponseTopic,
+			CorrelationData:        props.CorrelationData,
+			UserProperty:           props.User,
+			ContentType:            props.ContentType,
+		}
+	}
+	return pm
+}
+
+// willDelay returns the Will Delay Interval of a CONNECT.
+func willDelay(cr *packet.ConnectionRequest) time.Duration {
+	if props := cr.Will.Value().Properties; props != nil {
+		return time.Duration(props.WillDelayInterval) * time.Second
+	}
+	return 0
+}
+
+// scheduleWill publishes the will message of a closed connection once its Will Delay Interval passes,
+// or at once if the server is closed. The caller holds s.mu. [MQTT-3.1.3-9]
+func (s *Server) scheduleWill(sess *Session, will *packet.PublishMessage, delay time.Duration) {
+	if delay == 0 || s.closed {
+		s.publish(sess.ClientID, will)
+		return
+	}
+	sess.will = will
+	sess.willTimer = time.AfterFunc(delay, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if sess.will == will {
+			s.publishWill(sess)
+		}
+	})
+}
+
+// cancelWill discards the pending will message of a session resumed before its delay passed. The caller holds s.mu.
+func (s *Server) cancelWill(sess *Session) {
+	if sess.willTimer != nil {
+		sess.willTimer.Stop()
+		sess.willTimer = nil
+	}
+	sess.will = nil
+}
+
+// publishWill publishes the pending will message of a session, if any. The caller holds s.mu.
+func (s *Server) publishWill(sess *Session) {
+	will := sess.will
+	s.cancelWill(sess)
+	if will != nil {
+		s.publish(sess.ClientID, will)
+	}
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rwasayc/cactusmq/packet"
+)
+
+func willConnect(clientID string, delay uint32) *packet.ConnectionRequest {
+	return &packet.ConnectionRequest{
+		ClientID: clientID,
+		Properties: &packet.ConnectProperties{
+			SessionExpiryInterval: packet.NewFlagV[uint32](60),
+		},
+		Will: packet.NewFlagV(packet.ConnectWill{
+			Topic:   "lwt/" + clientID,
+			Payload: []byte("gone"),
+			Qos:     packet.NewFlagV(packet.QoS1),
+			Retain:  true,
+			Properties: &packet.WillProperties{
+				ContentType:           "text/plain",
+				MessageExpiryInterval: 30,
+				WillDelayInterval:     delay,
+			},
+		}),
+	}
+}
+
+func TestWillMessage(t *testing.T) {
+	pm := willMessage(willConnect("c", 5))
+	if pm.TopicName != "lwt/c" || pm.QoSLevel != packet.QoS1 || !pm.Retain || string(pm.Payload) != "gone" {
+		t.Errorf("unexpected will message %v", packet.JSON(pm))
+	}
+	if pm.Properties.ContentType != "text/plain" || pm.Properties.MessageExpiryInterval != 30 {
+		t.Errorf("expected the will properties to be kept but got %v", packet.JSON(pm.Properties))
+	}
+	if d := willDelay(willConnect("c", 5)); d != 5*time.Second {
+		t.Errorf("expected a delay of 5s but got %v", d)
+	}
+	if willMessage(&packet.ConnectionRequest{}) != nil {
+		t.Errorf("expected no will message")
+	}
+}
+
+func TestWillPublication(t *testing.T) {
+	s := startTestServer(t)
+	watcher := dialTestConn(t, s, packet.ProtoVer5)
+	watcher.connect(&packet.ConnectionRequest{ClientID: "watcher"})
+	watcher.subscribe(&packet.SubscribePayload{TopicFilter: "lwt/+", QoS: packet.QoS1})
+
+	t.Run("dropped connection", func(t *testing.T) {
+		tc := dialTestConn(t, s, packet.ProtoVer5)
+		tc.connect(willConnect("dropped", 0))
+		tc.conn.Close()
+		pm := watcher.readPublish()
+		if pm.TopicName != "lwt/dropped" || pm.Properties.ContentType != "text/plain" {
+			t.Errorf("unexpected will message %v", packet.JSON(pm))
+		}
+		watcher.write(&packet.PublishAcknowledgement{PacketID: pm.PacketID})
+	})
+
+	t.Run("disconnect with will", func(t *testing.T) {
+		tc := dialTestConn(t, s, packet.ProtoVer5)
+		tc.connect(willConnect("asked", 0))
+		tc.write(&packet.Disconnect{ReasonCode: packet.RCDisconnectWithWill})
+		pm := watcher.readPublish()
+		if pm.TopicName != "lwt/asked" {
+			t.Errorf("unexpected will message %v", packet.JSON(pm))
+		}
+		watcher.write(&packet.PublishAcknowledgement{PacketID: pm.PacketID})
+	})
+
+	t.Run("normal disconnect", func(t *testing.T) {
+		tc := dialTestConn(t, s, packet.ProtoVer5)
+		tc.connect(willConnect("normal", 0))
+		tc.write(&packet.Disconnect{})
+		tc.expectClosed()
+		watcher.write(&packet.PingRequest{})
+		if _, ok := watcher.read().(*packet.PingResponse); !ok {
+			t.Errorf("expected no will message after a normal disconnect")
+		}
+	})
+
+	t.Run("reconnect within delay", func(t *testing.T) {
+		tc := dialTestConn(t, s, packet.ProtoVer5)
+		tc.connect(willConnect("flaky", 1))
+		tc.conn.Close()
+		tc = dialTestConn(t, s, packet.ProtoVer5)
+		tc.connect(willConnect("flaky", 1))
+		time.Sleep(1500 * time.Millisecond)
+		watcher.write(&packet.PingRequest{})
+		if _, ok := watcher.read().(*packet.PingResponse); !ok {
+			t.Errorf("expected the will message to be cancelled by the reconnect")
+		}
+		tc.write(&packet.Disconnect{})
+		tc.expectClosed()
+	})
+
+	t.Run("delayed", func(t *testing.T) {
+		tc := dialTestConn(t, s, packet.ProtoVer5)
+		tc.connect(willConnect("late", 1))
+		start := time.Now()
+		tc.conn.Close()
+		pm := watcher.readPublish()
+		if pm.TopicName != "lwt/late" || time.Since(start) < time.Second {
+			t.Errorf("expected the will message after its delay")
+		}
+		watcher.write(&packet.PublishAcknowledgement{PacketID: pm.PacketID})
+	})
+
+	if retained := s.retained.match("lwt/#"); len(retained) != 3 {
+		t.Errorf("expected 3 retained will messages but got %d", len(retained))
+	}
+}
+
+func TestWillShutdown(t *testing.T) {
+	s := NewServer(WithAddress("127.0.0.1:0"))
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	closed := dialTestConn(t, s, packet.ProtoVer5)
+	closed.connect(willConnect("closed", 60))
+	closed.conn.Close()
+	waitFor(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		sess, _ := s.sessions.Load("closed")
+		return sess.willTimer != nil && sess.timer != nil
+	})
+	open := dialTestConn(t, s, packet.ProtoVer5)
+	open.connect(willConnect("open", 60))
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("failed to shutdown: %v", err)
+	}
+	if retained := s.retained.match("lwt/#"); len(retained) != 2 {
+		t.Errorf("expected the pending will messages to be published on shutdown but got %d", len(retained))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range []string{"closed", "open"} {
+		sess, ok := s.sessions.Load(id)
+		if !ok || sess.timer != nil || sess.willTimer != nil {
+			t.Errorf("expected session %s to be kept without timers", id)
+		}
+	}
+}